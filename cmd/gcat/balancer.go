@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+// Strategies of the balancer to pick an upstream.
+const (
+	balanceRoundRobin = "round-robin"
+	balanceLeastConn  = "least-conn"
+	balanceRandom     = "random"
+)
+
+type upstream struct {
+	desc    *proxy.ProxyDescription
+	active  int
+	healthy bool
+}
+
+// balancer distributes sessions across several upstream proxies.
+// Upstreams which fail to connect are marked unhealthy and the next
+// one is tried; unhealthy upstreams are only used as a last resort
+// until a health check or a successful connect revives them.
+type balancer struct {
+	strategy  string
+	logger    *slog.Logger
+	upstreams []*upstream
+
+	mutex    sync.Mutex
+	curIndex int
+}
+
+func newBalancer(strategy string, logger *slog.Logger, descs []*proxy.ProxyDescription) (*balancer, error) {
+	switch strategy {
+	case balanceRoundRobin, balanceLeastConn, balanceRandom:
+	default:
+		return nil, fmt.Errorf("invalid balance strategy: %s", strategy)
+	}
+
+	b := &balancer{strategy: strategy, logger: logger}
+	for _, desc := range descs {
+		if desc.IsListener() {
+			return nil, fmt.Errorf("%s: cannot balance across listeners", desc.Scheme)
+		}
+		b.upstreams = append(b.upstreams, &upstream{desc: desc, healthy: true})
+	}
+
+	return b, nil
+}
+
+// candidates returns the upstreams in the order in which they are
+// tried for the next session.
+func (b *balancer) candidates() []*upstream {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	out := make([]*upstream, 0, len(b.upstreams))
+
+	switch b.strategy {
+	case balanceRoundRobin:
+		for i := range b.upstreams {
+			out = append(out, b.upstreams[(b.curIndex+i)%len(b.upstreams)])
+		}
+		b.curIndex = (b.curIndex + 1) % len(b.upstreams)
+	case balanceRandom:
+		for _, i := range rand.Perm(len(b.upstreams)) {
+			out = append(out, b.upstreams[i])
+		}
+	case balanceLeastConn:
+		out = append(out, b.upstreams...)
+		sort.SliceStable(out, func(i, j int) bool {
+			return out[i].active < out[j].active
+		})
+	}
+
+	// Healthy upstreams first; the order within both groups is kept.
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].healthy && !out[j].healthy
+	})
+
+	return out
+}
+
+func (b *balancer) setHealthy(u *upstream, healthy bool, err error) {
+	b.mutex.Lock()
+	changed := u.healthy != healthy
+	u.healthy = healthy
+	b.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		b.logger.Info("upstream is healthy", "target", u.desc.Target().String())
+	} else {
+		b.logger.Warn("upstream is unhealthy", "target", u.desc.Target().String(), "error", err)
+	}
+}
+
+// Connect connects the next upstream and fails over to the other
+// upstreams on errors.
+func (b *balancer) Connect(ctx context.Context) (net.Conn, *proxy.ProxyDescription, error) {
+	var errs []error
+
+	for _, u := range b.candidates() {
+		conn, err := u.desc.Connect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			b.setHealthy(u, false, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		b.setHealthy(u, true, nil)
+
+		b.mutex.Lock()
+		u.active++
+		b.mutex.Unlock()
+
+		return &balancedConn{Conn: conn, release: func() {
+			b.mutex.Lock()
+			u.active--
+			b.mutex.Unlock()
+		}}, u.desc, nil
+	}
+
+	return nil, nil, fmt.Errorf("all upstreams failed: %w", errors.Join(errs...))
+}
+
+// HealthCheck probes the network upstreams every interval until ctx
+// is done and updates their health. Other upstreams, e.g. exec, are
+// not probed, as a probe would spawn a process; their health is
+// only updated by sessions.
+func (b *balancer) HealthCheck(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, u := range b.upstreams {
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			conn, err := u.desc.Probe(checkCtx)
+			cancel()
+
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, proxy.ErrNotSupported) {
+				continue
+			}
+			if err != nil {
+				b.setHealthy(u, false, err)
+				continue
+			}
+			conn.Close()
+			b.setHealthy(u, true, nil)
+		}
+	}
+}
+
+// balancedConn gives back its slot in the least-conn accounting
+// when closed.
+type balancedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *balancedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func (c *balancedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+func (c *balancedConn) ConnInfo() proxy.ConnInfo {
+	return proxy.GetConnInfo(c.Conn)
+}
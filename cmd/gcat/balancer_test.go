@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"golang.org/x/exp/slices"
+)
+
+var errUpstreamDown = errors.New("upstream down")
+
+// fakeUpstreams are the upstreams of the fake scheme by hostname;
+// the dialer fails for hosts which are down and records every dial.
+var fakeUpstreams = struct {
+	sync.Mutex
+	down  map[string]bool
+	dials []string
+}{down: make(map[string]bool)}
+
+type fakeDialer struct{}
+
+func (d *fakeDialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	host := desc.GetStringOption("Hostname")
+
+	fakeUpstreams.Lock()
+	defer fakeUpstreams.Unlock()
+
+	fakeUpstreams.dials = append(fakeUpstreams.dials, host)
+	if fakeUpstreams.down[host] {
+		return nil, errUpstreamDown
+	}
+
+	conn, peer := net.Pipe()
+	peer.Close()
+	return conn, nil
+}
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:    "fake",
+		NewDialer: func() proxy.ProxyDialer { return &fakeDialer{} },
+		StringOptions: []proxy.ProxyOption[string]{
+			{Name: "Hostname"},
+		},
+	})
+}
+
+func setDown(hosts ...string) {
+	fakeUpstreams.Lock()
+	defer fakeUpstreams.Unlock()
+
+	fakeUpstreams.down = make(map[string]bool)
+	for _, host := range hosts {
+		fakeUpstreams.down[host] = true
+	}
+	fakeUpstreams.dials = nil
+}
+
+func dials() []string {
+	fakeUpstreams.Lock()
+	defer fakeUpstreams.Unlock()
+
+	return append([]string(nil), fakeUpstreams.dials...)
+}
+
+func newTestBalancer(t *testing.T, strategy string, hosts ...string) *balancer {
+	t.Helper()
+
+	var descs []*proxy.ProxyDescription
+	for _, host := range hosts {
+		desc, err := createProxy("fake://" + host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		descs = append(descs, desc)
+	}
+
+	b, err := newBalancer(strategy, slog.New(slog.NewTextHandler(io.Discard, nil)), descs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDown()
+	return b
+}
+
+// connectHost connects b and returns the connection and the hostname
+// of the upstream.
+func connectHost(t *testing.T, b *balancer) (net.Conn, string) {
+	t.Helper()
+
+	conn, desc, err := b.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, desc.GetStringOption("Hostname")
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := newTestBalancer(t, balanceRoundRobin, "a", "b", "c")
+
+	for i, want := range []string{"a", "b", "c", "a"} {
+		if _, got := connectHost(t, b); got != want {
+			t.Fatalf("session %d: got %s; want %s", i, got, want)
+		}
+	}
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	b := newTestBalancer(t, balanceLeastConn, "a", "b", "c")
+
+	conns := make(map[string]net.Conn)
+	for i, want := range []string{"a", "b", "c"} {
+		conn, got := connectHost(t, b)
+		if got != want {
+			t.Fatalf("session %d: got %s; want %s", i, got, want)
+		}
+		conns[got] = conn
+	}
+
+	// Closing a session frees its upstream; closing twice does not
+	// count twice.
+	conns["b"].Close()
+	conns["b"].Close()
+
+	if _, got := connectHost(t, b); got != "b" {
+		t.Fatalf("got %s; want b", got)
+	}
+	if _, got := connectHost(t, b); got != "a" {
+		t.Fatalf("got %s; want a", got)
+	}
+}
+
+func TestBalancerRandom(t *testing.T) {
+	b := newTestBalancer(t, balanceRandom, "a", "b", "c")
+
+	for i := 0; i < 10; i++ {
+		seen := make(map[string]bool)
+		for _, u := range b.candidates() {
+			seen[u.desc.GetStringOption("Hostname")] = true
+		}
+		if len(seen) != 3 {
+			t.Fatalf("candidates %v are not a permutation", seen)
+		}
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	b := newTestBalancer(t, balanceRoundRobin, "a", "b", "c")
+
+	tests := []struct {
+		want  string
+		dials []string
+	}{
+		{"a", []string{"a"}},
+		// b fails and is tried last from now on.
+		{"c", []string{"b", "c"}},
+		{"c", []string{"c"}},
+		{"a", []string{"a"}},
+	}
+	for i, tt := range tests {
+		setDown("b")
+		if _, got := connectHost(t, b); got != tt.want {
+			t.Fatalf("session %d: got %s; want %s", i, got, tt.want)
+		}
+		if got := dials(); !slices.Equal(got, tt.dials) {
+			t.Fatalf("session %d: dialed %v; want %v", i, got, tt.dials)
+		}
+	}
+
+	// The unhealthy upstream is the last resort; connecting revives
+	// it.
+	setDown("a", "c")
+	if _, got := connectHost(t, b); got != "b" {
+		t.Fatalf("got %s; want b", got)
+	}
+	if got, want := dials(), []string{"c", "a", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("dialed %v; want %v", got, want)
+	}
+	for _, u := range b.upstreams {
+		if host := u.desc.GetStringOption("Hostname"); u.healthy != (host == "b") {
+			t.Fatalf("%s: healthy=%v", host, u.healthy)
+		}
+	}
+
+	setDown("a", "b", "c")
+	if _, _, err := b.Connect(context.Background()); !errors.Is(err, errUpstreamDown) {
+		t.Fatalf("got error %v; want %v", err, errUpstreamDown)
+	}
+}
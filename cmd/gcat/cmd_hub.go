@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"github.com/spf13/cobra"
+)
+
+type hubOptions struct {
+	prefix  bool
+	notices bool
+	queue   int
+	grace   time.Duration
+}
+
+// memberName identifies a hub member by its peer address; members
+// without one, e.g. stdio, are numbered instead.
+func memberName(conn net.Conn, n uint64) string {
+	if peer, _ := peerOf(conn); peer != "" {
+		return peer
+	}
+	return "member-" + strconv.FormatUint(n, 10)
+}
+
+var (
+	hubOpts hubOptions
+	hubCmd  = &cobra.Command{
+		Use:   "hub [flags] URL [URL...]",
+		Short: "Connect all clients of a listener to each other",
+		Long: `The hub command accepts clients on the listener given as the first URL
+and joins all of them to a shared bus: data written by one member is
+delivered to all other members. Further URLs are connected once and join
+the bus as permanent members, e.g. a serial console.
+
+Members which do not keep up with the others are disconnected; permanent
+members lose the data instead.
+
+On SIGINT/SIGTERM, all members are disconnected. Members which have not
+terminated after the grace period, e.g. a hanging command, are abandoned
+with exit code 5.`,
+		Example: `  Chat room on tcp port 1234:
+
+      $ gcat hub --prefix --notices tcp-listen://:1234
+
+  Shared serial console:
+
+      $ gcat hub --notices tcp-listen://:1234 exec:'picocom -b 115200 /dev/ttyUSB0'`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("provide at least one url")
+			}
+
+			ctx, force := handleSignals(hubOpts.grace)
+			logger := helper.GetLogger()
+
+			listener, err := createProxy(args[0])
+			if err != nil {
+				return err
+			}
+			if !listener.IsListener() || !listener.SupportsMultiple {
+				return fmt.Errorf("%s: hub requires a listener which supports multiple connections", listener.Scheme)
+			}
+
+			var members []*proxy.ProxyDescription
+			for _, arg := range args[1:] {
+				p, err := createProxy(arg)
+				if err != nil {
+					return err
+				}
+				members = append(members, p)
+			}
+
+			var (
+				hub = helper.NewHub(helper.HubOptions{
+					Prefix:  hubOpts.prefix,
+					Notices: hubOpts.notices,
+					Queue:   hubOpts.queue,
+				})
+				counter atomic.Uint64
+				wg      sync.WaitGroup
+			)
+
+			join := func(conn net.Conn, permanent bool) {
+				name := memberName(conn, counter.Add(1))
+				if gopts.verbose {
+					logger.Info("member joined", "name", name, "conn", proxy.GetConnInfo(conn))
+				}
+
+				var err error
+				if permanent {
+					err = hub.JoinPermanent(name, conn)
+				} else {
+					err = hub.Join(name, conn)
+				}
+
+				args := []any{"name", name, "members", hub.Len()}
+				if err != nil && !errors.Is(err, net.ErrClosed) {
+					args = append(args, "error", err)
+				}
+				logger.Info("member left", args...)
+				wg.Done()
+			}
+
+			// wait waits for the members to terminate after the
+			// hub has been closed.
+			wait := func() error {
+				done := make(chan struct{})
+				go func() {
+					wg.Wait()
+					close(done)
+				}()
+
+				select {
+				case <-done:
+					return nil
+				case <-force:
+					return errForcedClose
+				}
+			}
+
+			for _, p := range members {
+				conn, err := p.Connect(ctx)
+				if err != nil {
+					hub.Close()
+					return errors.Join(err, wait())
+				}
+				wg.Add(1)
+				go join(conn, true)
+			}
+
+			for {
+				conn, err := listener.Connect(ctx)
+				if err != nil {
+					listener.Close()
+					hub.Close()
+
+					if ctx.Err() != nil {
+						return wait()
+					}
+					return errors.Join(err, wait())
+				}
+				wg.Add(1)
+				go join(conn, false)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(hubCmd)
+	f := hubCmd.Flags()
+	f.BoolVar(&hubOpts.prefix, "prefix", false, "prefix every line with the name of its sender")
+	f.BoolVar(&hubOpts.notices, "notices", false, "announce members joining and leaving")
+	f.IntVar(&hubOpts.queue, "queue", 64, "number of messages buffered per member")
+	f.DurationVar(&hubOpts.grace, "grace", 10*time.Second, "time to wait for members to terminate on SIGINT/SIGTERM; a second signal exits immediately")
+}
@@ -2,92 +2,525 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/rumpelsepp/gcat/lib/helper"
 	"github.com/rumpelsepp/gcat/lib/proxy"
 	"github.com/spf13/cobra"
 
+	_ "github.com/rumpelsepp/gcat/lib/proxy/chaos"
+	_ "github.com/rumpelsepp/gcat/lib/proxy/compress"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/exec"
+	_ "github.com/rumpelsepp/gcat/lib/proxy/noise"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/quic"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/stdio"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/tcp"
+	_ "github.com/rumpelsepp/gcat/lib/proxy/text"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/tun"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/unix"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/websocket"
 	_ "github.com/rumpelsepp/gcat/lib/proxy/webtransport"
 )
 
+// Exit codes which allow scripts to distinguish configuration
+// errors from runtime failures.
+const (
+	exitFailure       = 1
+	exitNoSuchProxy   = 2
+	exitUnknownOption = 3
+	exitInvalidOption = 4
+	exitForcedClose   = 5
+)
+
+var errForcedClose = errors.New("active sessions were closed forcibly")
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, proxy.ErrNoSuchProxy):
+		return exitNoSuchProxy
+	case errors.Is(err, proxy.ErrUnknownOption):
+		return exitUnknownOption
+	case errors.Is(err, proxy.ErrInvalidOption):
+		return exitInvalidOption
+	case errors.Is(err, errForcedClose):
+		return exitForcedClose
+	default:
+		return exitFailure
+	}
+}
+
 type mainLoop struct {
-	proxyLeft  *proxy.ProxyDescription
-	proxyRight *proxy.ProxyDescription
-	ctx        context.Context
+	proxyLeft   *proxy.ProxyDescription
+	proxyRights []*proxy.ProxyDescription
+	ctx         context.Context
+	logger      *slog.Logger
+
+	// Broadcast to all right proxies if set.
+	tee *helper.TeeOptions
+	// Pick one of the right proxies per session if set.
+	balancer *balancer
+
+	// Log a summary line for every finished session.
+	logSessions bool
+	timeouts    helper.CopyTimeouts
+	limits      sessionLimits
+	slots       chan struct{}
+	// Print the traffic of all sessions if set.
+	dumper *helper.Dumper
+	// Record the traffic of all sessions if set.
+	pcap *helper.PcapWriter
+	// Record sessions as asciicast to this path if set; with
+	// recordPerSession, every session gets its own file.
+	record           string
+	recordPerSession bool
+	// Limit the bandwidth of the sessions if set.
+	rates rateLimits
+
+	mutex    sync.Mutex
+	closing  bool
+	nextID   uint64
+	perIP    map[string]int
+	sessions map[*session]struct{}
+	wg       sync.WaitGroup
 }
 
-func CreateLoop(addrLeft, addrRight string) (*mainLoop, error) {
-	addrLeftParsed, err := proxy.ParseAddr(addrLeft)
+func createProxy(rawURL string) (*proxy.ProxyDescription, error) {
+	addr, err := proxy.ParseAddr(rawURL)
 	if err != nil {
 		return nil, err
 	}
+	return proxy.Registry.FindAndCreateProxy(addr)
+}
+
+// openDumper creates a dumper writing to path, or to stderr if path
+// is empty.
+func openDumper(format, path string) (*helper.Dumper, error) {
+	if path == "" {
+		return helper.NewDumper(os.Stderr, helper.DumpFormat(format))
+	}
 
-	addrRightParsed, err := proxy.ParseAddr(addrRight)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
 	if err != nil {
 		return nil, err
 	}
-
-	proxyLeft, err := proxy.Registry.FindAndCreateProxy(addrLeftParsed)
+	d, err := helper.NewDumper(f, helper.DumpFormat(format))
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	return d, nil
+}
 
-	proxyRight, err := proxy.Registry.FindAndCreateProxy(addrRightParsed)
+func CreateLoop(ctx context.Context, addrLeft string, addrRights ...string) (*mainLoop, error) {
+	proxyLeft, err := createProxy(addrLeft)
 	if err != nil {
 		return nil, err
 	}
 
+	var proxyRights []*proxy.ProxyDescription
+	for _, addrRight := range addrRights {
+		proxyRight, err := createProxy(addrRight)
+		if err != nil {
+			return nil, err
+		}
+		proxyRights = append(proxyRights, proxyRight)
+	}
+
 	return &mainLoop{
-		proxyLeft:  proxyLeft,
-		proxyRight: proxyRight,
-		ctx:        context.Background(),
+		proxyLeft:   proxyLeft,
+		proxyRights: proxyRights,
+		ctx:         ctx,
+		logger:      helper.GetLogger(),
+		perIP:       make(map[string]int),
+		sessions:    make(map[*session]struct{}),
 	}, nil
 }
 
 func (l *mainLoop) SupportsMultiple() bool {
-	if !l.proxyLeft.SupportsMultiple || !l.proxyRight.SupportsMultiple {
+	if !l.proxyLeft.SupportsMultiple {
 		return false
 	}
+	for _, p := range l.proxyRights {
+		if !p.SupportsMultiple {
+			return false
+		}
+	}
 	return true
 }
 
-func (l *mainLoop) Connect() (net.Conn, net.Conn, error) {
+// connectRight connects the right side of a session. In tee mode,
+// all right proxies are connected and combined into a helper.Tee.
+func (l *mainLoop) connectRight() (net.Conn, error) {
+	if l.balancer != nil {
+		conn, desc, err := l.balancer.Connect(l.ctx)
+		if err != nil {
+			return nil, err
+		}
+		l.logConn(desc, conn)
+		return conn, nil
+	}
+
+	if l.tee == nil {
+		conn, err := l.proxyRights[0].Connect(l.ctx)
+		if err != nil {
+			return nil, err
+		}
+		l.logConn(l.proxyRights[0], conn)
+		return conn, nil
+	}
+
+	// Destinations which cannot be connected are skipped like
+	// destinations which fail later on.
+	var (
+		conns   []net.Conn
+		schemes []proxy.ProxyScheme
+		errs    []error
+	)
+	for _, p := range l.proxyRights {
+		conn, err := p.Connect(l.ctx)
+		if err != nil {
+			if l.tee.FailFast || l.ctx.Err() != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return nil, err
+			}
+			l.logger.Warn("tee destination failed", "scheme", p.Scheme, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		l.logConn(p, conn)
+		conns = append(conns, conn)
+		schemes = append(schemes, p.Scheme)
+	}
+
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("%w: %w", helper.ErrNoDestinations, errors.Join(errs...))
+	}
+
+	opts := *l.tee
+	opts.OnError = func(i int, err error) {
+		l.logger.Warn("tee destination failed", "scheme", schemes[i], "error", err)
+	}
+
+	return helper.NewTee(conns, opts), nil
+}
+
+func (l *mainLoop) proxies() []*proxy.ProxyDescription {
+	return append([]*proxy.ProxyDescription{l.proxyLeft}, l.proxyRights...)
+}
+
+// SetHandshakeTimeout limits the dials and handshakes of all proxies.
+func (l *mainLoop) SetHandshakeTimeout(timeout time.Duration) {
+	for _, p := range l.proxies() {
+		p.HandshakeTimeout = timeout
+	}
+}
+
+// SetReconnect applies policy to all dialers of the loop.
+func (l *mainLoop) SetReconnect(policy proxy.ReconnectPolicy) {
+	for _, p := range l.proxies() {
+		// Stdin cannot be reopened once it has reached EOF.
+		if p.Scheme == "stdio" {
+			continue
+		}
+
+		var (
+			scheme = p.Scheme
+			pol    = policy
+		)
+		pol.OnRetry = func(attempt int, delay time.Duration, err error) {
+			l.logger.Warn("reconnecting", "scheme", scheme, "attempt", attempt, "delay", delay, "error", err)
+		}
+		p.Reconnect = &pol
+	}
+}
+
+func (l *mainLoop) logConn(desc *proxy.ProxyDescription, conn net.Conn) {
+	if !gopts.verbose {
+		return
+	}
+	l.logger.Info("connected", "scheme", desc.Scheme, "conn", proxy.GetConnInfo(conn))
+}
+
+// Serve connects the two proxies once or repeatedly, depending on
+// loop and parallel. It returns nil when l.ctx is cancelled.
+func (l *mainLoop) Serve(loop, parallel bool) error {
+	for {
+		s, err := l.connect()
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, errSessionRejected) && (loop || parallel) {
+				continue
+			}
+			return err
+		}
+		if s == nil {
+			return nil
+		}
+
+		if parallel {
+			go l.runSession(s)
+		} else {
+			l.runSession(s)
+		}
+
+		if !loop && !parallel {
+			return nil
+		}
+	}
+}
+
+// connect establishes the next session. The right side is only
+// connected once the left connection has passed the session limits.
+func (l *mainLoop) connect() (*session, error) {
+	if !l.waitSlot() {
+		return nil, l.ctx.Err()
+	}
+
 	connLeft, err := l.proxyLeft.Connect(l.ctx)
 	if err != nil {
-		return nil, nil, err
+		l.cancelWait()
+		return nil, err
+	}
+
+	l.logConn(l.proxyLeft, connLeft)
+
+	peer, ip := peerOf(connLeft)
+	if err := l.admit(ip); err != nil {
+		connLeft.Close()
+		l.logger.Warn("rejected connection", "peer", peer, "error", err)
+		helper.Event(helper.EventRejected, "peer", peer, "error", err.Error())
+		return nil, err
 	}
 
-	connRight, err := l.proxyRight.Connect(l.ctx)
+	connRight, err := l.connectRight()
 	if err != nil {
-		return nil, nil, err
+		connLeft.Close()
+		l.release(ip)
+		// Keep serving; the upstreams might recover.
+		if l.balancer != nil && l.ctx.Err() == nil {
+			l.logger.Warn("rejected connection", "peer", peer, "error", err)
+			helper.Event(helper.EventRejected, "peer", peer, "error", err.Error())
+			return nil, fmt.Errorf("%w: %w", errSessionRejected, err)
+		}
+		return nil, err
 	}
 
-	return connLeft, connRight, nil
+	s := l.addSession(connLeft, connRight, peer, ip)
+	if s == nil {
+		l.release(ip)
+	} else {
+		helper.Event(helper.EventOpened, "session", s.id, "peer", peer)
+		if gopts.verbose {
+			l.logger.Info("session opened", "session", s.id, "peer", peer)
+		}
+	}
+
+	return s, nil
+}
+
+// Shutdown stops accepting new connections, waits until the active
+// sessions have finished or force is closed, and then closes the
+// remaining sessions. It returns errForcedClose if sessions had to
+// be closed forcibly.
+func (l *mainLoop) Shutdown(force <-chan struct{}) error {
+	l.mutex.Lock()
+	l.closing = true
+	l.mutex.Unlock()
+
+	for _, p := range l.proxies() {
+		if err := p.Close(); err != nil {
+			l.logger.Warn("closing listener failed", "scheme", p.Scheme, "error", err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-force:
+	}
+
+	l.mutex.Lock()
+	n := len(l.sessions)
+	for s := range l.sessions {
+		s.forced = true
+		s.Close()
+	}
+	l.mutex.Unlock()
+
+	<-drained
+
+	if n > 0 {
+		return fmt.Errorf("%w: %d", errForcedClose, n)
+	}
+	return nil
+}
+
+// handleSignals cancels the returned context on the first SIGINT
+// or SIGTERM. The returned channel is closed on a second signal or
+// when the grace period after the first signal has expired.
+func handleSignals(grace time.Duration) (context.Context, <-chan struct{}) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		force       = make(chan struct{})
+		sigCh       = make(chan os.Signal, 1)
+	)
+
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigCh
+		cancel()
+
+		select {
+		case <-sigCh:
+		case <-time.After(grace):
+		}
+		close(force)
+		signal.Stop(sigCh)
+	}()
+
+	return ctx, force
 }
 
 type proxyOptions struct {
-	loop     bool
-	parallel bool
+	loop        bool
+	parallel    bool
+	grace       time.Duration
+	maxSessions int
+	maxPerIP    int
+	overflow    string
+	tee         bool
+	teeMerge    bool
+	teePolicy   string
+	teeQueue    int
+	teeFailFast bool
+
+	balance        string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+
+	idleTimeout      time.Duration
+	maxLifetime      time.Duration
+	handshakeTimeout time.Duration
+
+	reconnect    bool
+	reconnectMax int
+	backoffMin   time.Duration
+	backoffMax   time.Duration
+	respawn      bool
+
+	dump     string
+	dumpFile string
+	pcap     string
+	record   string
+
+	rateUp        string
+	rateDown      string
+	rateUpTotal   string
+	rateDownTotal string
 }
 
 var (
 	proxyOpts proxyOptions
 	proxyCmd  = &cobra.Command{
-		Use:   "proxy [flags] URL1 URL2",
+		Use:   "proxy [flags] URL1 URL2 [URL3...]",
 		Short: "Act as a fancy socat like proxy tool",
 		Long: `The proxy command needs two arguments which specify the data pipeline.
 The arguments are URLs; in some rare cases it might be required to escape
 certain parts of the url. For more information to URLs see the "proxies"
 command.
+
+Proxy modules can be stacked by joining their schemes with "+"; the
+leftmost module runs on top of the connection produced by the module to
+its right. All layers share the same address and query options.
+
+On SIGINT or SIGTERM, listeners are closed and active sessions are given
+the grace period to finish; a second signal closes them immediately.
+The exit status is 5 if sessions had to be closed forcibly.
+
+With --parallel, every session gets an ID and a summary line with the
+peer, duration, transferred bytes and close reason is logged when it
+ends. --max-sessions limits the concurrent sessions; further
+connections are either not accepted until a session has finished
+(--overflow=queue) or closed right away (--overflow=reject).
+--max-per-ip rejects connections from source IPs which already have
+that many sessions.
+
+With --tee, the data of URL1 is broadcast to all following URLs. The
+replies of the destinations are discarded unless --tee-merge is set.
+--tee-policy decides what happens to a destination whose queue of
+--tee-queue writes is full: "block" slows down the whole session,
+"drop" discards data for this destination and "close" disconnects it.
+Failed destinations are removed from the session; with --tee-fail-fast
+the whole session is closed instead.
+
+With --balance, every session is connected to one of URL2 and the
+following URLs, picked by round-robin, least-conn or random. If an
+upstream fails to connect, it is marked unhealthy and the next one is
+tried. Unhealthy upstreams are only used if all others fail as well;
+network upstreams are probed every --health-interval to check whether
+they have recovered. The probe only dials the innermost module, e.g.
+tcp for tls+tcp; other upstreams, such as exec, recover with the next
+successful session.
+
+--idle-timeout closes sessions without data in either direction,
+--max-lifetime closes sessions after a fixed duration. Connections with
+deadline support are limited with deadlines; others, such as exec, are
+monitored by a watchdog. --handshake-timeout limits dials, including
+handshakes of stacked layers, and TLS handshakes of accepted clients.
+
+With --reconnect, dialers retry failed dials with exponential backoff
+between --backoff-min and --backoff-max, up to --reconnect-max attempts.
+--respawn additionally redials a dialer once its connection breaks
+during a session, e.g. when an exec'd ssh exits; the session survives,
+but data in flight might be lost.
+
+--dump prints every chunk passing through a session as hexdump (hex)
+or as escaped text (text), similar to "socat -x" and "socat -v". Each
+chunk is preceded by a timestamp, the session ID and its direction:
+">" is data from URL1 to URL2, "<" the reverse. The dump is written to
+stderr unless --dump-file is given.
+
+--pcap records every session as a synthetic TCP conversation in a
+pcapng file, e.g. for Wireshark. The conversations carry the addresses
+of the peers if available and the data as seen by gcat, i.e. decrypted
+for TLS or QUIC listeners.
+
+--record records sessions as asciicast v2, e.g. to document a caught
+shell. Data from URL1 to URL2 is recorded as terminal output, data
+from URL2 to URL1 as input. With --loop or --parallel, the session ID
+is appended to the file name. Recordings can be played back with the
+"replay" command or asciinema.
+
+--rate-up and --rate-down limit the bandwidth of every session in
+bytes per second, e.g. "1MiB/s" or "200KiB/s"; "up" is data from URL1
+to URL2, "down" the reverse. --rate-up-total and --rate-down-total
+limit all sessions together.
+
+Packet endpoints, i.e. tun, unixgram, unixpacket, QUIC datagrams and
+websockets with "messages=true", keep their message boundaries. If a
+packet endpoint is paired with a stream endpoint such as tcp or
+stdio, every datagram is sent as a length-prefixed frame over the
+stream; the other end of the stream must use gcat as well, e.g. as
+"frame+tcp" or as another packet/stream pair.
 `,
 		Example: `  Listen on localhost tcp port 1234 and proxy to stdio.
 
@@ -101,53 +534,157 @@ command.
 
       # gcat proxy "tun://192.168.255.1/24" exec:'ssh root@HOST gcat proxy tun://192.168.255.2/24 -'
 
+  The same tunnel, surviving network blips:
+
+      # gcat proxy --respawn "tun://192.168.255.1/24" exec:'ssh root@HOST gcat proxy tun://192.168.255.2/24 -'
+
   SSH Tunnel through Websocket (https://rumpelsepp.org/blog/ssh-through-websocket/):
 
-      $ ssh -o 'ProxyCommand=gcat proxy wss://example.org/ssh/ -' user@example.org`,
+      $ ssh -o 'ProxyCommand=gcat proxy wss://example.org/ssh/ -' user@example.org
+
+  Mirror TCP traffic to a production and a test backend:
+
+      $ gcat proxy -p --tee tcp-listen://:8080 tcp://prod:80 tcp://test:80
+
+  Balance across two backends with different transports:
+
+      $ gcat proxy -p --balance least-conn tcp-listen://:8080 tcp://backend1:80 tls://backend2:443
+
+  Inspect an unknown protocol between a client and its server:
+
+      $ gcat proxy -p --dump hex tcp-listen://:8080 tcp://target:8080
+
+  Catch a reverse shell and record it:
+
+      $ gcat proxy --record shell.cast tcp-listen://:4444 -
+
+  Simulate a slow uplink:
+
+      $ gcat proxy -p --rate-up 1MiB/s --rate-down 200KiB/s tcp-listen://:8080 tcp://target:8080
+
+  Test a client against a flaky backend:
+
+      $ gcat proxy -p tcp-listen://:8080 'chaos+tcp://target:8080?latency=100ms&jitter=50ms&reset_rate=0.001'
+
+  TLS through a Websocket tunnel:
+
+      $ gcat proxy tls-listen+ws-listen://localhost:8080/tunnel -
+      $ gcat proxy 'tls+ws://localhost:8080/tunnel?skip_verify=true' -`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) != 2 {
-				return fmt.Errorf("provide two urls")
+			if len(args) < 2 {
+				return fmt.Errorf("provide at least two urls")
 			}
+			if len(args) > 2 && !proxyOpts.tee && proxyOpts.balance == "" {
+				return fmt.Errorf("multiple destinations require --tee or --balance")
+			}
+			if proxyOpts.tee && proxyOpts.balance != "" {
+				return fmt.Errorf("--tee and --balance are mutually exclusive")
+			}
+
+			ctx, force := handleSignals(proxyOpts.grace)
 
-			loop, err := CreateLoop(args[0], args[1])
+			loop, err := CreateLoop(ctx, args[0], args[1:]...)
 			if err != nil {
 				return err
 			}
 
-			if proxyOpts.parallel {
-				if !loop.SupportsMultiple() {
-					return fmt.Errorf("multiple connections not supported by chosen pipeline")
+			if proxyOpts.tee {
+				switch policy := helper.TeePolicy(proxyOpts.teePolicy); policy {
+				case helper.TeeBlock, helper.TeeDrop, helper.TeeClose:
+					loop.tee = &helper.TeeOptions{
+						Policy:   policy,
+						Queue:    proxyOpts.teeQueue,
+						Merge:    proxyOpts.teeMerge,
+						FailFast: proxyOpts.teeFailFast,
+					}
+				default:
+					return fmt.Errorf("invalid tee policy: %s", proxyOpts.teePolicy)
 				}
+			}
 
-				for {
-					lConn, rConn, err := loop.Connect()
-					if err != nil {
-						return err
-					}
+			if proxyOpts.reconnect || proxyOpts.respawn {
+				loop.SetReconnect(proxy.ReconnectPolicy{
+					MaxAttempts: proxyOpts.reconnectMax,
+					MinDelay:    proxyOpts.backoffMin,
+					MaxDelay:    proxyOpts.backoffMax,
+					Jitter:      0.2,
+					Respawn:     proxyOpts.respawn,
+				})
+			}
 
-					go helper.BidirectCopy(lConn, rConn)
+			if proxyOpts.balance != "" {
+				loop.balancer, err = newBalancer(proxyOpts.balance, loop.logger, loop.proxyRights)
+				if err != nil {
+					return err
+				}
+				if proxyOpts.healthInterval > 0 {
+					go loop.balancer.HealthCheck(ctx, proxyOpts.healthInterval, proxyOpts.healthTimeout)
 				}
 			}
 
-			if proxyOpts.loop {
-				for {
-					lConn, rConn, err := loop.Connect()
-					if err != nil {
-						return err
-					}
+			if proxyOpts.parallel && !loop.SupportsMultiple() {
+				return fmt.Errorf("multiple connections not supported by chosen pipeline")
+			}
 
-					helper.BidirectCopy(lConn, rConn)
+			if proxyOpts.parallel {
+				if proxyOpts.overflow != "queue" && proxyOpts.overflow != "reject" {
+					return fmt.Errorf("invalid overflow mode: %s", proxyOpts.overflow)
 				}
+				loop.SetLimits(sessionLimits{
+					maxSessions: proxyOpts.maxSessions,
+					maxPerIP:    proxyOpts.maxPerIP,
+					reject:      proxyOpts.overflow == "reject",
+				})
 			}
+			loop.logSessions = proxyOpts.parallel || gopts.verbose
+			loop.timeouts = helper.CopyTimeouts{
+				Idle:     proxyOpts.idleTimeout,
+				Lifetime: proxyOpts.maxLifetime,
+			}
+			loop.SetHandshakeTimeout(proxyOpts.handshakeTimeout)
+
+			if proxyOpts.dump != "" {
+				loop.dumper, err = openDumper(proxyOpts.dump, proxyOpts.dumpFile)
+				if err != nil {
+					return err
+				}
+			}
+			if proxyOpts.pcap != "" {
+				f, err := os.Create(proxyOpts.pcap)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
 
-			lConn, rConn, err := loop.Connect()
+				loop.pcap, err = helper.NewPcapWriter(f)
+				if err != nil {
+					return err
+				}
+			}
+			loop.record = proxyOpts.record
+			loop.recordPerSession = proxyOpts.loop || proxyOpts.parallel
+
+			loop.rates, err = parseRateLimits(proxyOpts.rateUp, proxyOpts.rateDown, proxyOpts.rateUpTotal, proxyOpts.rateDownTotal)
 			if err != nil {
 				return err
 			}
 
-			helper.BidirectCopy(lConn, rConn)
-
-			return nil
+			serveCh := make(chan error, 1)
+			go func() {
+				serveCh <- loop.Serve(proxyOpts.loop, proxyOpts.parallel)
+			}()
+
+			select {
+			case err := <-serveCh:
+				// Serve() only returns with active sessions on
+				// errors; there is nothing to drain.
+				closed := make(chan struct{})
+				close(closed)
+				return errors.Join(err, loop.Shutdown(closed))
+			case <-ctx.Done():
+				shutdownErr := loop.Shutdown(force)
+				return errors.Join(<-serveCh, shutdownErr)
+			}
 		},
 	}
 )
@@ -157,4 +694,32 @@ func init() {
 	f := proxyCmd.Flags()
 	f.BoolVarP(&proxyOpts.loop, "loop", "l", false, "keep the listener running")
 	f.BoolVarP(&proxyOpts.parallel, "parallel", "p", false, "serve multiple connections in parallel")
+	f.DurationVar(&proxyOpts.grace, "grace", 10*time.Second, "time to drain active sessions on SIGINT/SIGTERM; a second signal force closes them")
+	f.IntVar(&proxyOpts.maxSessions, "max-sessions", 0, "limit the number of concurrent sessions with --parallel; 0 means unlimited")
+	f.IntVar(&proxyOpts.maxPerIP, "max-per-ip", 0, "limit the number of concurrent sessions per source IP with --parallel; 0 means unlimited")
+	f.StringVar(&proxyOpts.overflow, "overflow", "queue", "what to do with connections beyond --max-sessions: queue or reject")
+	f.BoolVar(&proxyOpts.tee, "tee", false, "broadcast the data of URL1 to all following URLs")
+	f.BoolVar(&proxyOpts.teeMerge, "tee-merge", false, "merge the replies of the tee destinations")
+	f.StringVar(&proxyOpts.teePolicy, "tee-policy", "block", "what to do with slow tee destinations: block, drop or close")
+	f.IntVar(&proxyOpts.teeQueue, "tee-queue", 64, "number of writes buffered per tee destination")
+	f.BoolVar(&proxyOpts.teeFailFast, "tee-fail-fast", false, "close the session if any tee destination fails")
+	f.StringVar(&proxyOpts.balance, "balance", "", "distribute sessions across URL2 and the following URLs: round-robin, least-conn or random")
+	f.DurationVar(&proxyOpts.healthInterval, "health-interval", 10*time.Second, "interval of the upstream health checks with --balance; 0 disables them")
+	f.DurationVar(&proxyOpts.healthTimeout, "health-timeout", 5*time.Second, "timeout of a single upstream health check")
+	f.DurationVar(&proxyOpts.idleTimeout, "idle-timeout", 0, "close sessions without data in either direction for this long; 0 disables it")
+	f.DurationVar(&proxyOpts.maxLifetime, "max-lifetime", 0, "close sessions after this duration; 0 disables it")
+	f.DurationVar(&proxyOpts.handshakeTimeout, "handshake-timeout", 0, "limit dials and the TLS handshakes of accepted connections; 0 disables it")
+	f.BoolVar(&proxyOpts.reconnect, "reconnect", false, "retry failed dials with exponential backoff")
+	f.IntVar(&proxyOpts.reconnectMax, "reconnect-max", 0, "maximum dial attempts with --reconnect; 0 means unlimited")
+	f.DurationVar(&proxyOpts.backoffMin, "backoff-min", time.Second, "initial delay between dial attempts")
+	f.DurationVar(&proxyOpts.backoffMax, "backoff-max", time.Minute, "maximum delay between dial attempts")
+	f.BoolVar(&proxyOpts.respawn, "respawn", false, "redial or respawn dialers transparently once their connection breaks; implies --reconnect")
+	f.StringVar(&proxyOpts.dump, "dump", "", "print the traffic of all sessions: hex or text")
+	f.StringVar(&proxyOpts.dumpFile, "dump-file", "", "append the dump to this file instead of stderr")
+	f.StringVar(&proxyOpts.pcap, "pcap", "", "record all sessions as TCP conversations to this pcapng file")
+	f.StringVar(&proxyOpts.record, "record", "", "record sessions as asciicast v2 to this file")
+	f.StringVar(&proxyOpts.rateUp, "rate-up", "", "limit the bandwidth from URL1 to URL2 per session, e.g. 1MiB/s")
+	f.StringVar(&proxyOpts.rateDown, "rate-down", "", "limit the bandwidth from URL2 to URL1 per session, e.g. 200KiB/s")
+	f.StringVar(&proxyOpts.rateUpTotal, "rate-up-total", "", "limit the bandwidth from URL1 to URL2 of all sessions together")
+	f.StringVar(&proxyOpts.rateDownTotal, "rate-down-total", "", "limit the bandwidth from URL2 to URL1 of all sessions together")
 }
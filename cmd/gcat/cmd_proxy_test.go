@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestLoop(t *testing.T) *mainLoop {
+	t.Helper()
+
+	l, err := CreateLoop(context.Background(), "tcp-listen://127.0.0.1:0", "tcp://127.0.0.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// startSession runs a session between two pipes and returns the
+// peers of its left and right side.
+func startSession(t *testing.T, l *mainLoop) (net.Conn, net.Conn) {
+	t.Helper()
+
+	var (
+		left, leftPeer   = net.Pipe()
+		right, rightPeer = net.Pipe()
+	)
+	t.Cleanup(func() {
+		leftPeer.Close()
+		rightPeer.Close()
+	})
+
+	s := l.addSession(left, right, "", "")
+	if s == nil {
+		t.Fatal("session was not added")
+	}
+	go l.runSession(s)
+
+	return leftPeer, rightPeer
+}
+
+func shutdown(l *mainLoop, force <-chan struct{}) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- l.Shutdown(force)
+	}()
+	return ch
+}
+
+func TestExitCode(t *testing.T) {
+	_, errNoSuchProxy := createProxy("nope://127.0.0.1:1")
+	_, errUnknownOption := createProxy("tcp://127.0.0.1:1?nope=1")
+	_, errInvalidOption := createProxy("chaos+tcp://127.0.0.1:1?reset_rate=nope")
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("connection refused"), exitFailure},
+		{errNoSuchProxy, exitNoSuchProxy},
+		{errUnknownOption, exitUnknownOption},
+		{errInvalidOption, exitInvalidOption},
+		{fmt.Errorf("%w: %d", errForcedClose, 1), exitForcedClose},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d; want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	var (
+		l                   = newTestLoop(t)
+		leftPeer, rightPeer = startSession(t, l)
+		force               = make(chan struct{})
+		ch                  = shutdown(l, force)
+	)
+
+	// The active session is still served during the grace period.
+	go leftPeer.Write([]byte("data"))
+	buf := make([]byte, 4)
+	if _, err := rightPeer.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// New sessions are refused.
+	left, right := net.Pipe()
+	if s := l.addSession(left, right, "", ""); s != nil {
+		t.Fatal("session added while shutting down")
+	}
+
+	leftPeer.Close()
+	rightPeer.Close()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+}
+
+func TestShutdownForce(t *testing.T) {
+	var (
+		l     = newTestLoop(t)
+		force = make(chan struct{})
+	)
+	startSession(t, l)
+	startSession(t, l)
+
+	ch := shutdown(l, force)
+
+	select {
+	case err := <-ch:
+		t.Fatalf("Shutdown returned before force: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(force)
+
+	select {
+	case err := <-ch:
+		if !errors.Is(err, errForcedClose) {
+			t.Fatalf("expected forced close; got %v", err)
+		}
+		if got := exitCode(err); got != exitForcedClose {
+			t.Fatalf("exitCode = %d; want %d", got, exitForcedClose)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+}
+
+func sendSignal(t *testing.T) {
+	t.Helper()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s was not closed", what)
+	}
+}
+
+func TestHandleSignalsGrace(t *testing.T) {
+	ctx, force := handleSignals(100 * time.Millisecond)
+
+	sendSignal(t)
+	waitClosed(t, ctx.Done(), "context")
+
+	start := time.Now()
+	waitClosed(t, force, "force channel")
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("force channel closed after %s; before the grace period", d)
+	}
+}
+
+func TestHandleSignalsSecondSignal(t *testing.T) {
+	ctx, force := handleSignals(time.Hour)
+
+	sendSignal(t)
+	waitClosed(t, ctx.Done(), "context")
+
+	select {
+	case <-force:
+		t.Fatal("force channel closed on the first signal")
+	default:
+	}
+
+	sendSignal(t)
+	waitClosed(t, force, "force channel")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/spf13/cobra"
+)
+
+type replayOptions struct {
+	speed   float64
+	maxWait time.Duration
+	input   bool
+}
+
+var (
+	replayOpts replayOptions
+	replayCmd  = &cobra.Command{
+		Use:   "replay [flags] FILE",
+		Short: "Play back a terminal recording",
+		Long: `The replay command plays back an asciicast v2 recording, as written by
+"proxy --record" or "serve ssh --record-dir", in the terminal with its
+original timing.`,
+		Example: `  Play back a recording at double speed, skipping long pauses:
+
+      $ gcat replay --speed 2 --max-wait 1s shell.cast`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("provide exactly one recording")
+			}
+			if replayOpts.speed <= 0 {
+				return fmt.Errorf("invalid speed: %g", replayOpts.speed)
+			}
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = helper.Replay(os.Stdout, f, helper.ReplayOptions{
+				Speed:   replayOpts.speed,
+				MaxWait: replayOpts.maxWait,
+				Input:   replayOpts.input,
+			})
+			return err
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	f := replayCmd.Flags()
+	f.Float64VarP(&replayOpts.speed, "speed", "s", 1, "playback speed factor")
+	f.DurationVar(&replayOpts.maxWait, "max-wait", 0, "limit pauses between events to this duration; 0 keeps the original timing")
+	f.BoolVar(&replayOpts.input, "input", false, "also play back the recorded input")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/rumpelsepp/gcat/lib/helper"
 	"github.com/spf13/cobra"
 )
 
@@ -12,13 +13,36 @@ type serveOptions struct {
 	requestLog  string
 }
 
+// serveAccessOptions restrict the clients of all servers; policy
+// is parsed from allow and deny before a server is started.
+type serveAccessOptions struct {
+	allow  []string
+	deny   []string
+	policy *helper.AccessPolicy
+}
+
 var (
-	serveOpts serveDOHOptions
+	serveOpts       serveDOHOptions
+	serveAccessOpts serveAccessOptions
 	serveCmd = &cobra.Command{
 		Use:   "serve",
 		Short: "Run a specific service",
 		Example: `  $ gcat serve http
-  $ gcat serve ssh -k /etc/ssh/ssh_host_ed25519_key -a ~/.ssh/authorized_keys`,
+  $ gcat serve ssh -k /etc/ssh/ssh_host_ed25519_key -a ~/.ssh/authorized_keys
+  $ gcat serve socks5 --allow 10.0.0.0/8 --deny 10.0.0.1`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := rootCmd.PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+
+			policy, err := helper.ParseAccessPolicy(serveAccessOpts.allow, serveAccessOpts.deny)
+			if err != nil {
+				return err
+			}
+			serveAccessOpts.policy = policy
+
+			return nil
+		},
 	}
 )
 
@@ -29,6 +53,8 @@ func init() {
 	sf.StringVarP(&serveOpts.path, "path", "p", "", "working dir for the server")
 	sf.StringVarP(&serveOpts.listen, "listen", "l", "localhost:1234", "listen address and port")
 	sf.StringVarP(&serveOpts.requestLog, "request-log", "r", "-", "path to request log; `-` means stdout")
+	sf.StringSliceVar(&serveAccessOpts.allow, "allow", nil, "accept only clients from these networks or addresses, e.g. 10.0.0.0/8")
+	sf.StringSliceVar(&serveAccessOpts.deny, "deny", nil, "reject clients from these networks or addresses; takes precedence over --allow")
 
 	rootCmd.AddCommand(serveCmd)
 }
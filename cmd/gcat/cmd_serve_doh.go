@@ -76,6 +76,8 @@ var (
 				TLSCertFile: serveDOHOpts.tlsCertFile,
 				TLSKeyFile:  serveDOHOpts.tlsKeyFile,
 				TLSConfig:   &tls.Config{},
+				Logger:      helper.GetLogger(),
+				Access:      serveAccessOpts.policy,
 			}
 
 			return server.Run()
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
 	"github.com/spf13/cobra"
 	"goftp.io/server/v2"
 	"goftp.io/server/v2/driver/file"
@@ -18,7 +21,16 @@ var (
 	serveFTPCmd  = &cobra.Command{
 		Use:   "ftp",
 		Short: "spawn a FTP server",
+		Long: `Spawn a FTP server.
+
+--allow and --deny only apply to the control connection. Passive data
+connections are accepted by the FTP library on ephemeral ports and are
+not checked; restrict them with a firewall if needed.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(serveAccessOpts.allow) > 0 || len(serveAccessOpts.deny) > 0 {
+				helper.GetLogger().Warn("access policy only applies to the ftp control connection")
+			}
+
 			driver, err := file.NewDriver(serveFTPOpts.root)
 			if err != nil {
 				return err
@@ -40,7 +52,11 @@ var (
 				return err
 			}
 
-			if err := ftpServer.ListenAndServe(); err != nil {
+			ln, err := helper.EventListen("tcp", fmt.Sprintf(":%d", serveFTPOpts.port), "ftp", serveAccessOpts.policy)
+			if err != nil {
+				return err
+			}
+			if err := ftpServer.Serve(ln); err != nil {
 				return err
 			}
 			return nil
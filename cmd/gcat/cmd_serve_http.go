@@ -27,7 +27,7 @@ var (
 				return err
 			}
 
-			if err := server.ListenAndServe(); err != nil {
+			if err := helper.ListenAndServeHTTP(server, "http", "", "", serveAccessOpts.policy); err != nil {
 				return err
 			}
 			return nil
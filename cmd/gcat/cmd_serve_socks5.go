@@ -1,15 +1,25 @@
 package main
 
 import (
+	"time"
+
 	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
 	"github.com/rumpelsepp/gcat/lib/server/socks5"
 	"github.com/spf13/cobra"
 )
 
 type serveSOCKS5Options struct {
-	listen   string
-	username string
-	password string
+	listen           string
+	username         string
+	password         string
+	idleTimeout      time.Duration
+	maxLifetime      time.Duration
+	handshakeTimeout time.Duration
+	dump             string
+	dumpFile         string
+	rateUp           string
+	rateDown         string
 }
 
 var (
@@ -23,12 +33,41 @@ var (
 				auth = socks5.AuthUsernamePassword
 			}
 
-			srv := socks5.Server{
+			srv := &socks5.Server{
 				Listen:   serveSOCKS5Opts.listen,
 				Logger:   helper.GetLogger(),
 				Auth:     auth,
 				Username: serveSOCKS5Opts.username,
 				Password: serveSOCKS5Opts.password,
+				Timeouts: helper.CopyTimeouts{
+					Idle:     serveSOCKS5Opts.idleTimeout,
+					Lifetime: serveSOCKS5Opts.maxLifetime,
+				},
+				HandshakeTimeout: serveSOCKS5Opts.handshakeTimeout,
+				Access:           serveAccessOpts.policy,
+			}
+
+			if serveSOCKS5Opts.dump != "" {
+				dumper, err := openDumper(serveSOCKS5Opts.dump, serveSOCKS5Opts.dumpFile)
+				if err != nil {
+					return err
+				}
+				srv.Dumper = dumper
+			}
+
+			if serveSOCKS5Opts.rateUp != "" {
+				rate, err := proxy.ParseRate(serveSOCKS5Opts.rateUp)
+				if err != nil {
+					return err
+				}
+				srv.RateUp = int64(rate)
+			}
+			if serveSOCKS5Opts.rateDown != "" {
+				rate, err := proxy.ParseRate(serveSOCKS5Opts.rateDown)
+				if err != nil {
+					return err
+				}
+				srv.RateDown = int64(rate)
 			}
 
 			return srv.ListenAndServe()
@@ -42,4 +81,11 @@ func init() {
 	f.StringVarP(&serveSOCKS5Opts.listen, "listen", "l", ":1080", "listen address")
 	f.StringVarP(&serveSOCKS5Opts.listen, "username", "u", "", "specify a username")
 	f.StringVarP(&serveSOCKS5Opts.listen, "password", "p", "", "specify a password")
+	f.DurationVar(&serveSOCKS5Opts.idleTimeout, "idle-timeout", 0, "close connections without data in either direction for this long; 0 disables it")
+	f.DurationVar(&serveSOCKS5Opts.maxLifetime, "max-lifetime", 0, "close connections after this duration; 0 disables it")
+	f.DurationVar(&serveSOCKS5Opts.handshakeTimeout, "handshake-timeout", 10*time.Second, "limit the SOCKS negotiation")
+	f.StringVar(&serveSOCKS5Opts.dump, "dump", "", "print the relayed traffic: hex or text")
+	f.StringVar(&serveSOCKS5Opts.dumpFile, "dump-file", "", "append the dump to this file instead of stderr")
+	f.StringVar(&serveSOCKS5Opts.rateUp, "rate-up", "", "limit the bandwidth from the clients per user, e.g. 1MiB/s")
+	f.StringVar(&serveSOCKS5Opts.rateDown, "rate-down", "", "limit the bandwidth to the clients per user, e.g. 200KiB/s")
 }
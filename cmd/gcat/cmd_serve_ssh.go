@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	gssh "github.com/rumpelsepp/gcat/lib/server/ssh"
 	"github.com/spf13/cobra"
 )
@@ -11,6 +13,7 @@ var (
 		Use:   "ssh",
 		Short: "spawn a SSH server with SFTP support",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			sshServer.Access = serveAccessOpts.policy
 			return sshServer.Run()
 		},
 	}
@@ -25,4 +28,9 @@ func init() {
 	f.StringVarP(&sshServer.Shell, "shell", "s", "/bin/bash", "shell to use")
 	f.StringVarP(&sshServer.HostKey, "host-key", "K", "", "path to host key file, if empty a random key is generated")
 	f.StringVarP(&sshServer.AuthorizedKeys, "authorized-keys", "a", "", "path to authorized_keys file")
+	f.DurationVar(&sshServer.Timeouts.Idle, "idle-timeout", 0, "close pty sessions without terminal data for this long; 0 disables it")
+	f.DurationVar(&sshServer.Timeouts.Lifetime, "max-lifetime", 0, "close connections after this duration; 0 disables it")
+	f.DurationVar(&sshServer.ConnIdleTimeout, "conn-idle-timeout", 0, "close connections without any traffic, including keepalives; 0 disables it")
+	f.DurationVar(&sshServer.HandshakeTimeout, "handshake-timeout", 30*time.Second, "limit the SSH handshake including authentication; 0 disables it")
+	f.StringVar(&sshServer.RecordDir, "record-dir", "", "record pty sessions as asciicast v2 files in this directory")
 }
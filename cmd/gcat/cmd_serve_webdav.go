@@ -23,6 +23,7 @@ var (
 				Logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
 				Root:   serveWebDAVOpts.root,
 				Listen: serveWebDAVOpts.address,
+				Access: serveAccessOpts.policy,
 			}
 
 			return srv.Run()
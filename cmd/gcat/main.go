@@ -2,9 +2,16 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
 	"github.com/spf13/cobra"
 )
 
@@ -30,7 +37,47 @@ func getVersion() string {
 }
 
 type globalOptions struct {
-	verbose bool
+	verbose       bool
+	events        string
+	metricsListen string
+}
+
+// serveMetrics exposes the metrics on addr at /metrics in the
+// background.
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	server, err := helper.NewHTTPServer(mux, addr, "", nil)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	go server.Serve(ln)
+
+	return nil
+}
+
+// openEvents opens the destination of the event stream: a file or
+// an inherited file descriptor given as "fd:N".
+func openEvents(dest string) (io.Writer, error) {
+	if fd, ok := strings.CutPrefix(dest, "fd:"); ok {
+		n, err := strconv.Atoi(fd)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid file descriptor: %s", fd)
+		}
+		f := os.NewFile(uintptr(n), dest)
+		if _, err := f.Stat(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
 }
 
 var (
@@ -50,12 +97,31 @@ However, "gcat" provides the following delta to "socat":
     specified as command line arguments. The "proxy" command uses URLs for its arguments.`,
 		Version:      getVersion(),
 		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if gopts.events != "" {
+				w, err := openEvents(gopts.events)
+				if err != nil {
+					return err
+				}
+				helper.SetEventWriter(w)
+			}
+			if gopts.metricsListen != "" {
+				if err := serveMetrics(gopts.metricsListen); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
 	}
 )
 
 func main() {
 	gf := rootCmd.PersistentFlags()
 	gf.BoolVarP(&gopts.verbose, "verbose", "v", false, "enable verbose logging")
+	gf.StringVar(&gopts.metricsListen, "metrics-listen", "", "serve Prometheus metrics at http://ADDR/metrics")
+	gf.StringVar(&gopts.events, "events", "", "write machine-readable events as JSON lines to this file or to an inherited file descriptor, e.g. fd:3")
 
-	rootCmd.Execute()
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(exitCode(err))
+	}
 }
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"github.com/rumpelsepp/gcat/lib/proxy/frame"
+	"golang.org/x/term"
+)
+
+var errSessionRejected = errors.New("session rejected")
+
+// session is a pair of connected endpoints which are copied
+// into each other.
+type session struct {
+	id     uint64
+	peer   string
+	ip     string
+	start  time.Time
+	left   net.Conn
+	right  net.Conn
+	forced bool
+}
+
+func (s *session) Close() {
+	s.left.Close()
+	s.right.Close()
+}
+
+// sessionLimits restricts the concurrent sessions in parallel mode.
+// Zero values mean unlimited.
+type sessionLimits struct {
+	maxSessions int
+	maxPerIP    int
+	// Reject connections beyond maxSessions instead of not
+	// accepting them until a session has finished.
+	reject bool
+}
+
+// peerOf returns a printable peer address and its IP address; the
+// IP address is empty if the connection has none, e.g. stdio.
+func peerOf(conn net.Conn) (string, string) {
+	addr := proxy.GetConnInfo(conn).RemoteAddr
+	if addr == nil {
+		return "", ""
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.String(), a.IP.String()
+	case *net.UDPAddr:
+		return a.String(), a.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return addr.String(), ""
+	}
+	return addr.String(), host
+}
+
+func (l *mainLoop) SetLimits(limits sessionLimits) {
+	l.limits = limits
+	if limits.maxSessions > 0 {
+		l.slots = make(chan struct{}, limits.maxSessions)
+	}
+}
+
+// waitSlot blocks until a session slot is available; it returns
+// false if the loop is shutting down.
+func (l *mainLoop) waitSlot() bool {
+	if l.slots == nil || l.limits.reject {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-l.ctx.Done():
+		return false
+	}
+}
+
+// admit checks the limits for a new connection and reserves its
+// resources; release() gives them back.
+func (l *mainLoop) admit(ip string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.slots != nil && l.limits.reject {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return fmt.Errorf("%w: too many sessions", errSessionRejected)
+		}
+	}
+
+	if ip != "" && l.limits.maxPerIP > 0 && l.perIP[ip] >= l.limits.maxPerIP {
+		l.releaseSlot()
+		return fmt.Errorf("%w: too many sessions from %s", errSessionRejected, ip)
+	}
+
+	if ip != "" {
+		l.perIP[ip]++
+	}
+
+	return nil
+}
+
+func (l *mainLoop) release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if ip != "" {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+	l.releaseSlot()
+}
+
+// cancelWait gives back the slot of waitSlot() if the connection
+// never reached admit().
+func (l *mainLoop) cancelWait() {
+	if !l.limits.reject {
+		l.releaseSlot()
+	}
+}
+
+func (l *mainLoop) releaseSlot() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// addSession registers a new session; it returns nil and closes
+// the connections if the loop is shutting down.
+func (l *mainLoop) addSession(left, right net.Conn, peer, ip string) *session {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.nextID++
+
+	s := &session{
+		id:    l.nextID,
+		peer:  peer,
+		ip:    ip,
+		start: time.Now(),
+		left:  left,
+		right: right,
+	}
+
+	if l.closing {
+		s.Close()
+		return nil
+	}
+
+	l.sessions[s] = struct{}{}
+	l.wg.Add(1)
+	metrics.SessionsActive.Inc("proxy")
+
+	return s
+}
+
+func (l *mainLoop) runSession(s *session) {
+	leftConn, right, datagrams := frameStreamSide(s.left, s.right)
+	if datagrams {
+		l.logger.Debug("copying datagrams", "session", s.id)
+	}
+
+	var left io.ReadWriteCloser = helper.MeterConn(leftConn, "proxy", "left_to_right", "right_to_left")
+	if l.rates.enabled() {
+		left = l.rates.wrap(left, datagrams)
+	}
+	if l.record != "" {
+		rec, f, err := l.startRecording(s)
+		if err != nil {
+			l.logger.Warn("could not start recording", "session", s.id, "error", err)
+		} else {
+			defer f.Close()
+			left = rec.Wrap(left)
+		}
+	}
+	if l.dumper != nil {
+		left = l.dumper.Wrap(left, s.id)
+	}
+	if l.pcap != nil {
+		client, server := pcapEndpoints(s.left, s.right)
+		left = l.pcap.Wrap(left, client, server)
+	}
+
+	copyFunc := helper.BidirectCopyTimeout
+	if datagrams {
+		copyFunc = helper.BidirectCopyDatagrams
+	}
+	n1, n2, err := copyFunc(left, right, l.timeouts)
+
+	metrics.SessionsActive.Dec("proxy")
+
+	l.mutex.Lock()
+	delete(l.sessions, s)
+	forced := s.forced
+	l.mutex.Unlock()
+
+	l.release(s.ip)
+
+	var (
+		duration = time.Since(s.start)
+		reason   = closeReason(forced, err)
+	)
+
+	eventArgs := []any{
+		"session", s.id,
+		"peer", s.peer,
+		"duration", duration.Seconds(),
+		"left_to_right", n1,
+		"right_to_left", n2,
+		"reason", reason,
+	}
+	if err != nil && reason != "eof" {
+		eventArgs = append(eventArgs, "error", err.Error())
+	}
+	compression := compressionSummary(s)
+	eventArgs = append(eventArgs, compression...)
+	helper.Event(helper.EventClosed, eventArgs...)
+
+	if l.logSessions {
+		args := []any{
+			"session", s.id,
+			"peer", s.peer,
+			"duration", duration.Round(time.Millisecond),
+			"left_to_right", n1,
+			"right_to_left", n2,
+			"reason", reason,
+		}
+		if tee, ok := s.right.(*helper.Tee); ok {
+			args = append(args, teeSummary(tee))
+		}
+		args = append(args, compression...)
+		l.logger.Info("session closed", args...)
+	}
+
+	l.wg.Done()
+}
+
+// rateLimits are the bandwidth limits of the sessions. The total
+// limiters are shared by all sessions; the per session limiters are
+// created for every session. Zero values and nil mean unlimited.
+type rateLimits struct {
+	up, down           proxy.Size
+	upTotal, downTotal *helper.RateLimiter
+}
+
+// parseRateLimits parses the rate flags; empty strings mean
+// unlimited.
+func parseRateLimits(up, down, upTotal, downTotal string) (rateLimits, error) {
+	var (
+		limits rateLimits
+		rates  = make([]proxy.Size, 4)
+	)
+	for i, s := range []string{up, down, upTotal, downTotal} {
+		if s == "" {
+			continue
+		}
+		rate, err := proxy.ParseRate(s)
+		if err != nil {
+			return limits, err
+		}
+		rates[i] = rate
+	}
+
+	limits.up, limits.down = rates[0], rates[1]
+	limits.upTotal = newRateLimiter(rates[2])
+	limits.downTotal = newRateLimiter(rates[3])
+	return limits, nil
+}
+
+// newRateLimiter returns nil for rate 0, i.e. unlimited.
+func newRateLimiter(rate proxy.Size) *helper.RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return helper.NewRateLimiter(int64(rate))
+}
+
+func (r rateLimits) enabled() bool {
+	return r.up > 0 || r.down > 0 || r.upTotal != nil || r.downTotal != nil
+}
+
+// wrap limits the left side of a session; reads from it are
+// upstream.
+func (r rateLimits) wrap(left io.ReadWriteCloser, datagrams bool) io.ReadWriteCloser {
+	rateLimit := helper.RateLimit
+	if datagrams {
+		rateLimit = helper.RateLimitDatagrams
+	}
+	return rateLimit(left,
+		[]*helper.RateLimiter{newRateLimiter(r.up), r.upTotal},
+		[]*helper.RateLimiter{newRateLimiter(r.down), r.downTotal},
+	)
+}
+
+// frameStreamSide pairs a packet endpoint, e.g. tun or unixgram,
+// with a stream endpoint: the stream side is wrapped with length
+// prefixed framing, such that datagrams keep their boundaries. It
+// reports whether the session carries datagrams.
+func frameStreamSide(left, right net.Conn) (net.Conn, net.Conn, bool) {
+	var (
+		leftDatagrams  = proxy.GetConnInfo(left).Datagrams
+		rightDatagrams = proxy.GetConnInfo(right).Datagrams
+	)
+
+	switch {
+	case leftDatagrams && !rightDatagrams:
+		right = frame.Wrap(right, frame.DefaultMaxSize)
+	case rightDatagrams && !leftDatagrams:
+		left = frame.Wrap(left, frame.DefaultMaxSize)
+	}
+	return left, right, leftDatagrams || rightDatagrams
+}
+
+// recordPath inserts the session ID before the extension of path
+// if the loop runs more than one session.
+func (l *mainLoop) recordPath(id uint64) string {
+	if !l.recordPerSession {
+		return l.record
+	}
+	ext := filepath.Ext(l.record)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(l.record, ext), id, ext)
+}
+
+// startRecording records session s as asciicast; data from the left
+// side is the output of the terminal.
+func (l *mainLoop) startRecording(s *session) (*helper.Recorder, *os.File, error) {
+	f, err := os.Create(l.recordPath(s.id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The size of our own terminal if there is one.
+	width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+
+	title := fmt.Sprintf("gcat session %d", s.id)
+	if s.ip != "" {
+		title += " from " + s.peer
+	}
+
+	rec, err := helper.NewRecorder(f, helper.CastHeader{
+		Width:  width,
+		Height: height,
+		Title:  title,
+		Env:    map[string]string{"TERM": os.Getenv("TERM")},
+	})
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return rec, f, nil
+}
+
+// pcapEndpoints returns the addresses of the client and the server
+// of a session; the server is the peer of the right side if it has
+// one and the local address of the left side otherwise.
+func pcapEndpoints(left, right net.Conn) (net.Addr, net.Addr) {
+	var (
+		leftInfo  = proxy.GetConnInfo(left)
+		rightInfo = proxy.GetConnInfo(right)
+		server    = rightInfo.RemoteAddr
+	)
+	if server == nil {
+		server = leftInfo.LocalAddr
+	}
+	return leftInfo.RemoteAddr, server
+}
+
+// teeSummary groups the accounting of the tee destinations by
+// their index.
+func teeSummary(tee *helper.Tee) slog.Attr {
+	var attrs []any
+	for i, stats := range tee.Stats() {
+		group := []any{"written", stats.Written, "dropped", stats.Dropped}
+		if stats.Err != nil {
+			group = append(group, "error", stats.Err)
+		}
+		attrs = append(attrs, slog.Group(strconv.Itoa(i), group...))
+	}
+	return slog.Group("tee", attrs...)
+}
+
+// compressionSummary returns the statistics of compression layers
+// on either side of s.
+func compressionSummary(s *session) []any {
+	var attrs []any
+	for _, side := range []struct {
+		name string
+		conn net.Conn
+	}{{"left", s.left}, {"right", s.right}} {
+		stats := proxy.GetConnInfo(side.conn).Compression
+		if stats == nil || stats.Algorithm() == "" {
+			continue
+		}
+		attrs = append(attrs, slog.Group(side.name+"_compression",
+			"algorithm", stats.Algorithm(),
+			"raw", stats.RawIn.Load()+stats.RawOut.Load(),
+			"wire", stats.WireIn.Load()+stats.WireOut.Load(),
+			"ratio", math.Round(stats.Ratio()*100)/100,
+		))
+	}
+	return attrs
+}
+
+func closeReason(forced bool, err error) string {
+	switch {
+	case forced:
+		return "shutdown"
+	case errors.Is(err, helper.ErrIdleTimeout), errors.Is(err, helper.ErrLifetimeExceeded):
+		return err.Error()
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	// A copier which reaches EOF half-closes the other side; sides
+	// without half-close are closed entirely, which ends the reverse
+	// copier with net.ErrClosed.
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return "eof"
+	default:
+		return err.Error()
+	}
+}
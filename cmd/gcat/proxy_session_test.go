@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Outcomes of a connection attempt.
+const (
+	admitted = "admitted"
+	rejected = "rejected"
+	queued   = "queued"
+)
+
+type limitStep struct {
+	ip      string
+	release bool
+	want    string
+}
+
+// acquire runs the admission of connect() for a connection from ip.
+func acquire(l *mainLoop, ip string) string {
+	if !l.waitSlot() {
+		return queued
+	}
+	if err := l.admit(ip); err != nil {
+		if !errors.Is(err, errSessionRejected) {
+			panic(err)
+		}
+		return rejected
+	}
+	return admitted
+}
+
+func TestSessionLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits sessionLimits
+		steps  []limitStep
+		// Slots and per IP counters left in use.
+		slots int
+		perIP map[string]int
+	}{
+		{
+			name:   "unlimited",
+			limits: sessionLimits{},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "", want: admitted},
+			},
+			perIP: map[string]int{"10.0.0.1": 2},
+		},
+		{
+			name:   "total reject",
+			limits: sessionLimits{maxSessions: 2, reject: true},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.2", want: admitted},
+				{ip: "10.0.0.3", want: rejected},
+				{ip: "10.0.0.1", release: true},
+				{ip: "10.0.0.3", want: admitted},
+			},
+			slots: 2,
+			perIP: map[string]int{"10.0.0.2": 1, "10.0.0.3": 1},
+		},
+		{
+			name:   "total queue",
+			limits: sessionLimits{maxSessions: 1},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.2", want: queued},
+				{ip: "10.0.0.1", release: true},
+				{ip: "10.0.0.2", want: admitted},
+			},
+			slots: 1,
+			perIP: map[string]int{"10.0.0.2": 1},
+		},
+		{
+			name:   "per IP",
+			limits: sessionLimits{maxPerIP: 1},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.1", want: rejected},
+				{ip: "10.0.0.2", want: admitted},
+				{ip: "10.0.0.1", release: true},
+				{ip: "10.0.0.1", want: admitted},
+			},
+			perIP: map[string]int{"10.0.0.1": 1, "10.0.0.2": 1},
+		},
+		{
+			name:   "per IP without address",
+			limits: sessionLimits{maxPerIP: 1},
+			steps: []limitStep{
+				{ip: "", want: admitted},
+				{ip: "", want: admitted},
+			},
+			perIP: map[string]int{},
+		},
+		{
+			// Rejected connections give back their session slot.
+			name:   "per IP reject returns slot",
+			limits: sessionLimits{maxSessions: 2, maxPerIP: 1, reject: true},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.1", want: rejected},
+				{ip: "10.0.0.1", want: rejected},
+				{ip: "10.0.0.2", want: admitted},
+				{ip: "10.0.0.3", want: rejected},
+			},
+			slots: 2,
+			perIP: map[string]int{"10.0.0.1": 1, "10.0.0.2": 1},
+		},
+		{
+			name:   "per IP queue returns slot",
+			limits: sessionLimits{maxSessions: 2, maxPerIP: 1},
+			steps: []limitStep{
+				{ip: "10.0.0.1", want: admitted},
+				{ip: "10.0.0.1", want: rejected},
+				{ip: "10.0.0.2", want: admitted},
+				{ip: "10.0.0.3", want: queued},
+			},
+			slots: 2,
+			perIP: map[string]int{"10.0.0.1": 1, "10.0.0.2": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &mainLoop{perIP: make(map[string]int)}
+			l.SetLimits(tt.limits)
+
+			for i, step := range tt.steps {
+				if step.release {
+					l.release(step.ip)
+					continue
+				}
+
+				// A queued connection waits until the loop is
+				// shut down.
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				l.ctx = ctx
+				got := acquire(l, step.ip)
+				cancel()
+
+				if got != step.want {
+					t.Fatalf("step %d (%s): got %s; want %s", i, step.ip, got, step.want)
+				}
+			}
+
+			if len(l.slots) != tt.slots {
+				t.Errorf("%d slots in use; want %d", len(l.slots), tt.slots)
+			}
+			if len(l.perIP) != len(tt.perIP) {
+				t.Errorf("per IP counters %v; want %v", l.perIP, tt.perIP)
+			}
+			for ip, n := range tt.perIP {
+				if l.perIP[ip] != n {
+					t.Errorf("per IP counters %v; want %v", l.perIP, tt.perIP)
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestWaitSlotRelease checks that a queued connection is accepted
+// as soon as a session has finished.
+func TestWaitSlotRelease(t *testing.T) {
+	l := &mainLoop{ctx: context.Background(), perIP: make(map[string]int)}
+	l.SetLimits(sessionLimits{maxSessions: 1})
+
+	if got := acquire(l, "10.0.0.1"); got != admitted {
+		t.Fatalf("got %s", got)
+	}
+
+	ch := make(chan string, 1)
+	go func() {
+		ch <- acquire(l, "10.0.0.2")
+	}()
+
+	select {
+	case got := <-ch:
+		t.Fatalf("not queued: %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.release("10.0.0.1")
+
+	select {
+	case got := <-ch:
+		if got != admitted {
+			t.Fatalf("got %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued connection was not accepted")
+	}
+}
+
+// TestCancelWait checks that a connection which fails before
+// admit() gives back its slot in queue mode only.
+func TestCancelWait(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		l := &mainLoop{ctx: context.Background(), perIP: make(map[string]int)}
+		l.SetLimits(sessionLimits{maxSessions: 1, reject: reject})
+
+		if !l.waitSlot() {
+			t.Fatal("waitSlot failed")
+		}
+		l.cancelWait()
+
+		if len(l.slots) != 0 {
+			t.Fatalf("reject=%v: %d slots in use", reject, len(l.slots))
+		}
+	}
+}
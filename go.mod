@@ -9,6 +9,7 @@ require (
 	github.com/gorilla/handlers v1.5.1
 	github.com/jba/muxpatterns v0.3.0
 	github.com/jedib0t/go-pretty/v6 v6.4.7
+	github.com/klauspost/compress v1.16.7
 	github.com/miekg/dns v1.1.56
 	github.com/pkg/sftp v1.13.6
 	github.com/quic-go/quic-go v0.39.0
@@ -16,10 +17,12 @@ require (
 	github.com/spf13/cobra v1.7.0
 	github.com/vishvananda/netlink v1.2.1-beta.2
 	goftp.io/server/v2 v2.0.1
+	golang.org/x/crypto v0.13.0
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
 	golang.org/x/net v0.15.0
 	golang.org/x/sys v0.12.0
 	golang.org/x/term v0.12.0
+	golang.org/x/text v0.13.0
 	nhooyr.io/websocket v1.8.7
 )
 
@@ -37,7 +40,6 @@ require (
 	github.com/google/pprof v0.0.0-20230906154834-20cde9067b3b // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/klauspost/compress v1.16.7 // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/kyokomi/emoji/v2 v2.2.12 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
@@ -51,9 +53,7 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/vishvananda/netns v0.0.4 // indirect
 	go.uber.org/mock v0.3.0 // indirect
-	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/image v0.12.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
-	golang.org/x/text v0.13.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
 )
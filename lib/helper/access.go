@@ -0,0 +1,205 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// AccessPolicy restricts the peers of a listener. Allow and Deny
+// apply to peers with an IP address: deny rules take precedence and
+// if Allow is not empty, only matching peers are accepted. UIDs
+// restricts the peers of unix sockets to these user IDs.
+type AccessPolicy struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	UIDs  []uint32
+}
+
+// ParsePrefix parses a CIDR prefix, e.g. `10.0.0.0/8`, or a single
+// IP address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ParseUID parses a numeric user ID or looks up a user name.
+func ParseUID(s string) (uint32, error) {
+	if uid, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(uid), nil
+}
+
+// ParseAccessPolicy parses the CIDR rules of command line flags; the
+// policy is nil if there are no rules.
+func ParseAccessPolicy(allow, deny []string) (*AccessPolicy, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+
+	var policy AccessPolicy
+	for _, rule := range allow {
+		prefix, err := ParsePrefix(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow rule: %w", err)
+		}
+		policy.Allow = append(policy.Allow, prefix)
+	}
+	for _, rule := range deny {
+		prefix, err := ParsePrefix(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny rule: %w", err)
+		}
+		policy.Deny = append(policy.Deny, prefix)
+	}
+	return &policy, nil
+}
+
+// Check returns an error wrapping ErrAccessDenied if the peer of
+// conn is rejected. A nil policy accepts everyone.
+func (p *AccessPolicy) Check(conn net.Conn) error {
+	if p == nil {
+		return nil
+	}
+
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return p.checkUID(unixConn)
+	}
+	return p.CheckAddr(conn.RemoteAddr())
+}
+
+// CheckAddr applies the CIDR rules to addr, e.g. if the connection
+// is hidden by a HTTP server; addresses without IP pass.
+func (p *AccessPolicy) CheckAddr(addr net.Addr) error {
+	if p == nil {
+		return nil
+	}
+
+	ip, ok := addrIP(addr)
+	if !ok {
+		return nil
+	}
+	return p.checkIP(ip)
+}
+
+func (p *AccessPolicy) checkIP(ip netip.Addr) error {
+	contains := func(prefix netip.Prefix) bool {
+		return prefix.Contains(ip)
+	}
+
+	if slices.ContainsFunc(p.Deny, contains) {
+		return fmt.Errorf("%w: %s is denied", ErrAccessDenied, ip)
+	}
+	if len(p.Allow) > 0 && !slices.ContainsFunc(p.Allow, contains) {
+		return fmt.Errorf("%w: %s is not allowed", ErrAccessDenied, ip)
+	}
+	return nil
+}
+
+func (p *AccessPolicy) checkUID(conn *net.UnixConn) error {
+	if len(p.UIDs) == 0 {
+		return nil
+	}
+
+	uid, err := peerUID(conn)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	if !slices.Contains(p.UIDs, uid) {
+		return fmt.Errorf("%w: uid %d is not allowed", ErrAccessDenied, uid)
+	}
+	return nil
+}
+
+// addrIP extracts the IP address of addr; IPv4-mapped IPv6
+// addresses are converted to IPv4, such that they match IPv4 rules.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip netip.Addr
+
+	switch a := addr.(type) {
+	case nil:
+		return ip, false
+	case *net.TCPAddr:
+		ip = a.AddrPort().Addr()
+	case *net.UDPAddr:
+		ip = a.AddrPort().Addr()
+	default:
+		addrPort, err := netip.ParseAddrPort(addr.String())
+		if err != nil {
+			return ip, false
+		}
+		ip = addrPort.Addr()
+	}
+
+	return ip.Unmap(), ip.IsValid()
+}
+
+// LogRejected logs a connection which was rejected by an
+// AccessPolicy and emits the rejected event; source identifies the
+// listener, e.g. "service", "socks5".
+func LogRejected(remote string, err error, source ...any) {
+	source = slices.Clip(source)
+	GetLogger().Warn("rejected connection", append(source, "remote", remote, "error", err)...)
+	Event(EventRejected, append(source, "remote", remote, "error", err.Error())...)
+}
+
+// NewAccessListener closes the connections of ln which are rejected
+// by policy right after Accept(). A nil policy returns ln as is.
+func NewAccessListener(ln net.Listener, policy *AccessPolicy, service string) net.Listener {
+	if policy == nil {
+		return ln
+	}
+	return &accessListener{Listener: ln, policy: policy, service: service}
+}
+
+type accessListener struct {
+	net.Listener
+	policy  *AccessPolicy
+	service string
+}
+
+func (ln *accessListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ln.policy.Check(conn); err != nil {
+			LogRejected(conn.RemoteAddr().String(), err, "service", ln.service)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package helper
+
+import (
+	"errors"
+	"net"
+)
+
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
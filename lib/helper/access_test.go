@@ -0,0 +1,70 @@
+package helper
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	policy, err := ParseAccessPolicy([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, allowed := range map[string]bool{
+		"10.1.2.3:80":         true,
+		"[::ffff:10.1.2.3]:1": true,
+		"[2001:db8::1]:443":   true,
+		"10.0.0.1:80":         false,
+		"192.168.1.1:80":      false,
+	} {
+		err := policy.CheckAddr(net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr)))
+		if allowed && err != nil {
+			t.Errorf("%s: unexpected error: %s", addr, err)
+		}
+		if !allowed && !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("%s: got %v; expected %v", addr, err, ErrAccessDenied)
+		}
+	}
+
+	if _, err := ParseAccessPolicy([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("expected error for invalid prefix")
+	}
+}
+
+func TestAccessPolicyUID(t *testing.T) {
+	path := t.TempDir() + "/gcat.sock"
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			if conn, err := net.Dial("unix", path); err == nil {
+				defer conn.Close()
+			}
+		}
+	}()
+
+	uid := uint32(os.Getuid())
+	for _, policy := range []*AccessPolicy{{UIDs: []uint32{uid}}, {UIDs: []uint32{uid + 1}}} {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		err = policy.Check(conn)
+		if policy.UIDs[0] == uid && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if policy.UIDs[0] != uid && !errors.Is(err, ErrAccessDenied) {
+			t.Fatalf("got %v; expected %v", err, ErrAccessDenied)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader is the first line of an asciicast v2 recording.
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is a single event of an asciicast v2 recording: "o" is
+// output, "i" is input and "r" is a resize to "COLSxROWS".
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, e.Type, e.Data})
+}
+
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("invalid event: %s", b)
+	}
+	if err := json.Unmarshal(raw[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw[2], &e.Data)
+}
+
+// Recorder writes a terminal session as asciicast v2, as used by
+// asciinema.
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+	// Incomplete UTF-8 sequences at the end of the last chunk per
+	// event type.
+	pending map[string][]byte
+}
+
+// NewRecorder writes header to w; a zero terminal size defaults
+// to 80x24.
+func NewRecorder(w io.Writer, header CastHeader) (*Recorder, error) {
+	header.Version = 2
+	if header.Width <= 0 || header.Height <= 0 {
+		header.Width, header.Height = 80, 24
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = time.Now().Unix()
+	}
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		w:       w,
+		start:   time.Now(),
+		pending: make(map[string][]byte),
+	}, nil
+}
+
+// splitIncomplete splits off a trailing incomplete UTF-8 sequence.
+func splitIncomplete(p []byte) ([]byte, []byte) {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return p[:len(p)-i], p[len(p)-i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
+
+func (r *Recorder) event(typ string, p []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	data := append(r.pending[typ], p...)
+	data, r.pending[typ] = splitIncomplete(data)
+	if len(data) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(CastEvent{
+		Time: time.Since(r.start).Seconds(),
+		Type: typ,
+		Data: string(data),
+	})
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// Output records data shown on the terminal.
+func (r *Recorder) Output(p []byte) {
+	r.event("o", p)
+}
+
+// Input records data typed on the terminal.
+func (r *Recorder) Input(p []byte) {
+	r.event("i", p)
+}
+
+// Resize records a change of the terminal size.
+func (r *Recorder) Resize(width, height int) {
+	r.event("r", []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+// Wrap records the traffic of conn, which is the terminal side of a
+// session: reads are recorded as output and writes as input.
+func (r *Recorder) Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &tapConn{ReadWriteCloser: conn, tap: (*recorderTap)(r)}
+}
+
+type recorderTap Recorder
+
+func (t *recorderTap) data(out bool, p []byte) {
+	if out {
+		(*Recorder)(t).Output(p)
+	} else {
+		(*Recorder)(t).Input(p)
+	}
+}
+
+func (t *recorderTap) fin(out bool) {}
+
+// ReplayOptions control Replay().
+type ReplayOptions struct {
+	// Speed scales the time between events; 2 plays twice as fast.
+	Speed float64
+	// MaxWait caps the time between events; 0 means unlimited.
+	MaxWait time.Duration
+	// Input also plays back the input events.
+	Input bool
+}
+
+// Replay plays the asciicast v2 recording of r to w with the
+// original timing.
+func Replay(w io.Writer, r io.Reader, opts ReplayOptions) (*CastHeader, error) {
+	var (
+		scanner = bufio.NewScanner(r)
+		header  CastHeader
+		last    float64
+	)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty recording")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version: %d", header.Version)
+	}
+
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ev CastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return &header, err
+		}
+		if ev.Type != "o" && (ev.Type != "i" || !opts.Input) {
+			continue
+		}
+
+		delay := time.Duration((ev.Time - last) / speed * float64(time.Second))
+		if opts.MaxWait > 0 && delay > opts.MaxWait {
+			delay = opts.MaxWait
+		}
+		time.Sleep(delay)
+		last = ev.Time
+
+		if _, err := io.WriteString(w, ev.Data); err != nil {
+			return &header, err
+		}
+	}
+
+	return &header, scanner.Err()
+}
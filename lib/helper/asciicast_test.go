@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecorderReplay(t *testing.T) {
+	var cast bytes.Buffer
+
+	rec, err := NewRecorder(&cast, CastHeader{Title: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "é" is split across two chunks.
+	rec.Output([]byte("h\xc3"))
+	rec.Output([]byte("\xa9llo\n"))
+	rec.Input([]byte("exit\n"))
+
+	var out bytes.Buffer
+	header, err := Replay(&out, &cast, ReplayOptions{Speed: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Title != "test" || header.Width != 80 {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	if out.String() != "héllo\n" {
+		t.Fatalf("got %q", out.String())
+	}
+}
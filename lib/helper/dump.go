@@ -0,0 +1,102 @@
+package helper
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DumpFormat selects how Dumper prints the traffic.
+type DumpFormat string
+
+const (
+	DumpHex  DumpFormat = "hex"
+	DumpText DumpFormat = "text"
+)
+
+// Dumper prints a timestamped and direction tagged dump of every
+// chunk passing through the wrapped connections, similar to
+// `socat -x` and `socat -v`.
+type Dumper struct {
+	format DumpFormat
+	mutex  sync.Mutex
+	w      io.Writer
+}
+
+func NewDumper(w io.Writer, format DumpFormat) (*Dumper, error) {
+	switch format {
+	case DumpHex, DumpText:
+	default:
+		return nil, fmt.Errorf("invalid dump format: %s", format)
+	}
+	return &Dumper{format: format, w: w}, nil
+}
+
+// escapeText keeps printable ASCII and newlines; everything else
+// is escaped.
+func escapeText(p []byte) string {
+	var b strings.Builder
+	for _, c := range p {
+		switch {
+		case c == '\n':
+			b.WriteString("\\n\n")
+		case c == '\r':
+			b.WriteString("\\r")
+		case c == '\t':
+			b.WriteString("\\t")
+		case c == '\\':
+			b.WriteString("\\\\")
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+
+	out := b.String()
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// Dump prints p; dir is ">" for data from the client side and "<"
+// for data to the client side.
+func (d *Dumper) Dump(session uint64, dir string, p []byte) {
+	var body string
+	switch d.format {
+	case DumpHex:
+		body = hex.Dump(p)
+	case DumpText:
+		body = escapeText(p)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fmt.Fprintf(d.w, "%s session=%d %s length=%d\n%s", time.Now().Format("2006-01-02T15:04:05.000000"), session, dir, len(p), body)
+}
+
+// Wrap dumps the traffic of conn, which is the client side of a
+// session: reads are tagged ">" and writes "<".
+func (d *Dumper) Wrap(conn io.ReadWriteCloser, session uint64) io.ReadWriteCloser {
+	return &tapConn{ReadWriteCloser: conn, tap: &dumpTap{dumper: d, session: session}}
+}
+
+type dumpTap struct {
+	dumper  *Dumper
+	session uint64
+}
+
+func (t *dumpTap) data(out bool, p []byte) {
+	if out {
+		t.dumper.Dump(t.session, ">", p)
+	} else {
+		t.dumper.Dump(t.session, "<", p)
+	}
+}
+
+func (t *dumpTap) fin(out bool) {}
@@ -0,0 +1,77 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+)
+
+// bufferConn reads from r and writes to w.
+type bufferConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *bufferConn) Close() error {
+	return nil
+}
+
+// timestamps matches the volatile timestamps of dump headers.
+var timestamps = regexp.MustCompile(`(?m)^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6} `)
+
+func TestDumper(t *testing.T) {
+	tests := []struct {
+		format DumpFormat
+		in     string
+		out    string
+		want   string
+	}{
+		{
+			format: DumpHex,
+			in:     "hello, world\x00\x01\xff more bytes",
+			out:    "ok\n",
+			want: `TIME session=7 > length=26
+00000000  68 65 6c 6c 6f 2c 20 77  6f 72 6c 64 00 01 ff 20  |hello, world... |
+00000010  6d 6f 72 65 20 62 79 74  65 73                    |more bytes|
+TIME session=7 < length=3
+00000000  6f 6b 0a                                          |ok.|
+`,
+		},
+		{
+			format: DumpText,
+			in:     "GET / HTTP/1.1\r\n\tx\x00\\",
+			out:    "ok",
+			want: `TIME session=7 > length=20
+GET / HTTP/1.1\r\n
+\tx\x00\\
+TIME session=7 < length=2
+ok
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		d, err := NewDumper(&out, tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn := d.Wrap(&bufferConn{Reader: bytes.NewBufferString(tt.in), Writer: io.Discard}, 7)
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte(tt.out)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := timestamps.ReplaceAllString(out.String(), "TIME "); got != tt.want {
+			t.Fatalf("%s: got\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := NewDumper(io.Discard, "binary"); err == nil {
+		t.Fatal("invalid format accepted")
+	}
+}
@@ -0,0 +1,191 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/metrics"
+)
+
+// Names of the events of the machine-readable event stream.
+const (
+	EventListening = "listening"
+	EventAccepted  = "accepted"
+	EventDialed    = "dialed"
+	EventHandshake = "handshake"
+	EventRejected  = "rejected"
+	EventOpened    = "opened"
+	EventClosed    = "closed"
+	EventCert      = "certificate"
+)
+
+var events atomic.Pointer[slog.Logger]
+
+// SetEventWriter enables the event stream; every event is written
+// to w as a JSON object per line, e.g.
+//
+//	{"time":"...","event":"accepted","proxy":"tcp-listen","remote":"127.0.0.1:4711"}
+func SetEventWriter(w io.Writer) {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				return slog.Attr{}
+			case slog.MessageKey:
+				a.Key = "event"
+			}
+			return a
+		},
+	})
+	events.Store(slog.New(handler))
+}
+
+// Event emits an event with the given attributes; it does nothing
+// unless SetEventWriter() was called.
+func Event(name string, args ...any) {
+	if l := events.Load(); l != nil {
+		l.Log(context.Background(), slog.LevelInfo, name, args...)
+	}
+}
+
+// EventListen is net.Listen() which emits events for the listener
+// and its connections; service names the server, e.g. "socks5".
+// Connections rejected by policy are dropped before they are
+// accounted; a nil policy accepts everyone.
+func EventListen(network, address, service string, policy *AccessPolicy) (net.Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventListener(NewAccessListener(ln, policy, service), service), nil
+}
+
+// NewEventListener emits the listening event for ln and the
+// accepted and closed events for its connections. The connections
+// are accounted in the metrics as well.
+func NewEventListener(ln net.Listener, service string) net.Listener {
+	Event(EventListening, "service", service, "addr", ln.Addr().String())
+	return &eventListener{Listener: ln, service: service}
+}
+
+type eventListener struct {
+	net.Listener
+	service string
+}
+
+func (ln *eventListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ConnectionsAccepted.Inc(ln.service)
+	metrics.SessionsActive.Inc(ln.service)
+	Event(EventAccepted, "service", ln.service, "local", conn.LocalAddr().String(), "remote", conn.RemoteAddr().String())
+	return &eventConn{Conn: conn, service: ln.service, start: time.Now()}, nil
+}
+
+// eventConn counts the transferred bytes and emits the closed event
+// once it is closed.
+type eventConn struct {
+	net.Conn
+	service string
+	start   time.Time
+	in, out atomic.Int64
+
+	once  sync.Once
+	mutex sync.Mutex
+	err   error
+}
+
+func (c *eventConn) setErr(err error) {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *eventConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(int64(n))
+	metrics.Bytes.Add(float64(n), c.service, "in")
+	c.setErr(err)
+	return n, err
+}
+
+func (c *eventConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(int64(n))
+	metrics.Bytes.Add(float64(n), c.service, "out")
+	c.setErr(err)
+	return n, err
+}
+
+func (c *eventConn) CloseWrite() error {
+	if cw, ok := c.Conn.(CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("half-close not supported")
+}
+
+func (c *eventConn) Close() error {
+	err := c.Conn.Close()
+
+	c.once.Do(func() {
+		metrics.SessionsActive.Dec(c.service)
+
+		args := []any{
+			"service", c.service,
+			"remote", c.RemoteAddr().String(),
+			"duration", time.Since(c.start).Seconds(),
+			"bytes_in", c.in.Load(),
+			"bytes_out", c.out.Load(),
+		}
+
+		c.mutex.Lock()
+		if c.err != nil {
+			args = append(args, "error", c.err.Error())
+		}
+		c.mutex.Unlock()
+
+		Event(EventClosed, args...)
+	})
+
+	return err
+}
+
+// MeterConn adds the traffic of conn, which is the client side of a
+// session, to the byte metrics of service; reads are counted with
+// the direction out and writes with in.
+func MeterConn(conn io.ReadWriteCloser, service, out, in string) io.ReadWriteCloser {
+	return &tapConn{ReadWriteCloser: conn, tap: &meterTap{service: service, out: out, in: in}}
+}
+
+type meterTap struct {
+	service, out, in string
+}
+
+func (t *meterTap) data(out bool, p []byte) {
+	if out {
+		metrics.Bytes.Add(float64(len(p)), t.service, t.out)
+	} else {
+		metrics.Bytes.Add(float64(len(p)), t.service, t.in)
+	}
+}
+
+func (t *meterTap) fin(out bool) {}
@@ -0,0 +1,109 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// eventSchema lists the attributes of the events with their JSON
+// types; "time" and "event" are part of every event.
+var eventSchema = map[string]map[string]string{
+	EventListening: {"service": "string", "addr": "string"},
+	EventAccepted:  {"service": "string", "local": "string", "remote": "string"},
+	EventClosed: {
+		"service":   "string",
+		"remote":    "string",
+		"duration":  "number",
+		"bytes_in":  "number",
+		"bytes_out": "number",
+	},
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	default:
+		return "object"
+	}
+}
+
+func TestEventSchema(t *testing.T) {
+	var out bytes.Buffer
+	SetEventWriter(&out)
+	defer events.Store(nil)
+
+	ln, err := EventListen("tcp", "127.0.0.1:0", "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("pong!")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	conn.Close()
+
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid JSON %q: %s", line, err)
+		}
+
+		name, _ := ev["event"].(string)
+		schema, ok := eventSchema[name]
+		if !ok {
+			t.Fatalf("unexpected event %q", line)
+		}
+		if _, ok := ev["time"].(string); !ok {
+			t.Fatalf("event without time: %q", line)
+		}
+		if len(ev) != len(schema)+2 {
+			t.Fatalf("unexpected attributes: %q", line)
+		}
+		for key, typ := range schema {
+			if got := jsonType(ev[key]); got != typ {
+				t.Fatalf("%s: %s is %s; want %s", name, key, got, typ)
+			}
+		}
+
+		if name == EventClosed && (ev["bytes_in"] != 4.0 || ev["bytes_out"] != 5.0) {
+			t.Fatalf("unexpected byte counters: %q", line)
+		}
+		names = append(names, name)
+	}
+
+	// The closed event is emitted once.
+	want := []string{EventListening, EventAccepted, EventClosed}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("got events %v; want %v", names, want)
+	}
+}
@@ -33,3 +33,18 @@ func NewHTTPServer(handler http.Handler, listen, requestLog string, tlsConfig *t
 		IdleTimeout:  60 * time.Second,
 	}, nil
 }
+
+// ListenAndServeHTTP runs server on a listener which emits events;
+// TLS is enabled if certFile and keyFile are given. service names
+// the server in the events; policy restricts the clients if set.
+func ListenAndServeHTTP(server *http.Server, service, certFile, keyFile string, policy *AccessPolicy) error {
+	ln, err := EventListen("tcp", server.Addr, service, policy)
+	if err != nil {
+		return err
+	}
+
+	if certFile != "" && keyFile != "" {
+		return server.ServeTLS(ln, certFile, keyFile)
+	}
+	return server.Serve(ln)
+}
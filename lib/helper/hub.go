@@ -0,0 +1,229 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ErrSlowMember = errors.New("hub member too slow")
+
+type HubOptions struct {
+	// Prefix prepends the name of the sender to every line.
+	Prefix bool
+	// Notices announces members joining and leaving.
+	Notices bool
+	// Queue is the number of messages buffered per member; members
+	// which do not keep up are disconnected. Permanent members lose
+	// the messages instead.
+	Queue int
+}
+
+type hubMember struct {
+	name  string
+	conn  io.ReadWriteCloser
+	queue chan []byte
+	// Permanent members are never disconnected for being slow.
+	permanent bool
+	// Set while a permanent member loses messages.
+	dropping bool
+	// Set while the next data of this member starts a new line.
+	lineStart bool
+}
+
+// Hub is a shared bus: data read from one member is written to all
+// other members.
+type Hub struct {
+	opts    HubOptions
+	mutex   sync.Mutex
+	closed  bool
+	members map[*hubMember]struct{}
+}
+
+func NewHub(opts HubOptions) *Hub {
+	if opts.Queue <= 0 {
+		opts.Queue = 64
+	}
+	return &Hub{
+		opts:    opts,
+		members: make(map[*hubMember]struct{}),
+	}
+}
+
+// Join adds conn to the hub and blocks until it leaves. conn is
+// closed afterwards.
+func (h *Hub) Join(name string, conn io.ReadWriteCloser) error {
+	return h.join(name, conn, false)
+}
+
+// JoinPermanent is like Join, but conn is not disconnected if it does
+// not keep up; messages are dropped instead. This is meant for members
+// which cannot reconnect, e.g. a serial console.
+func (h *Hub) JoinPermanent(name string, conn io.ReadWriteCloser) error {
+	return h.join(name, conn, true)
+}
+
+func (h *Hub) join(name string, conn io.ReadWriteCloser, permanent bool) error {
+	m := &hubMember{
+		name:      name,
+		conn:      conn,
+		queue:     make(chan []byte, h.opts.Queue),
+		permanent: permanent,
+		lineStart: true,
+	}
+
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		conn.Close()
+		return io.ErrClosedPipe
+	}
+	h.members[m] = struct{}{}
+	h.mutex.Unlock()
+
+	go h.write(m)
+
+	h.notice(m, "joined")
+
+	var (
+		buf = make([]byte, 32*1024)
+		err error
+	)
+	for {
+		var n int
+		n, err = conn.Read(buf)
+		if n > 0 {
+			h.broadcast(m, h.format(m, buf[:n]))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if h.leave(m) {
+		h.notice(m, "left")
+	}
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (h *Hub) write(m *hubMember) {
+	for b := range m.queue {
+		if _, err := m.conn.Write(b); err != nil {
+			break
+		}
+	}
+	// Unblocks the reader in Join().
+	m.conn.Close()
+}
+
+// leave removes and disconnects m; it reports false if m was gone
+// already.
+func (h *Hub) leave(m *hubMember) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.members[m]; !ok {
+		return false
+	}
+	delete(h.members, m)
+	close(m.queue)
+	m.conn.Close()
+
+	return true
+}
+
+// format prepends the name of m to every line of b if enabled.
+func (h *Hub) format(m *hubMember, b []byte) []byte {
+	if !h.opts.Prefix {
+		return bytes.Clone(b)
+	}
+
+	var (
+		out    bytes.Buffer
+		prefix = fmt.Sprintf("[%s] ", m.name)
+	)
+	for len(b) > 0 {
+		if m.lineStart {
+			out.WriteString(prefix)
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			out.Write(b)
+			m.lineStart = false
+			break
+		}
+		out.Write(b[:i+1])
+		b = b[i+1:]
+		m.lineStart = true
+	}
+	return out.Bytes()
+}
+
+func (h *Hub) notice(m *hubMember, what string) {
+	if h.opts.Notices {
+		h.broadcast(m, []byte(fmt.Sprintf("*** %s %s\n", m.name, what)))
+	}
+}
+
+// broadcast sends b to all members except from.
+func (h *Hub) broadcast(from *hubMember, b []byte) {
+	h.mutex.Lock()
+	var slow, dropping []*hubMember
+	for m := range h.members {
+		if m == from {
+			continue
+		}
+		select {
+		case m.queue <- b:
+			m.dropping = false
+		default:
+			if !m.permanent {
+				slow = append(slow, m)
+			} else if !m.dropping {
+				m.dropping = true
+				dropping = append(dropping, m)
+			}
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, m := range dropping {
+		GetLogger().Warn("dropping hub messages", "name", m.name, "error", ErrSlowMember)
+	}
+
+	for _, m := range slow {
+		if h.leave(m) {
+			h.notice(m, fmt.Sprintf("left (%s)", ErrSlowMember))
+		}
+	}
+}
+
+// Len returns the number of members.
+func (h *Hub) Len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.members)
+}
+
+// Close disconnects all members; later calls to Join() fail.
+func (h *Hub) Close() error {
+	h.mutex.Lock()
+	h.closed = true
+	members := make([]*hubMember, 0, len(h.members))
+	for m := range h.members {
+		members = append(members, m)
+	}
+	h.mutex.Unlock()
+
+	for _, m := range members {
+		h.leave(m)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package helper
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinHub joins a pipe to h and returns its peer once the member is
+// registered.
+func joinHub(t *testing.T, h *Hub, name string, permanent bool) net.Conn {
+	t.Helper()
+
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+
+	n := h.Len()
+	if permanent {
+		go h.JoinPermanent(name, conn)
+	} else {
+		go h.Join(name, conn)
+	}
+	waitHubLen(t, h, n+1)
+
+	return peer
+}
+
+func waitHubLen(t *testing.T, h *Hub, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("hub has %d members; want %d", h.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func readHub(t *testing.T, conn net.Conn, n int) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestHubPrefix(t *testing.T) {
+	var (
+		h = NewHub(HubOptions{Prefix: true})
+		a = joinHub(t, h, "a", false)
+		b = joinHub(t, h, "b", false)
+	)
+	defer h.Close()
+
+	// Lines split across reads are prefixed once.
+	for _, s := range []string{"hello\nwor", "ld\n"} {
+		if _, err := a.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "[a] hello\n[a] world\n"
+	if got := readHub(t, b, len(want)); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestHubNotices(t *testing.T) {
+	var (
+		h = NewHub(HubOptions{Notices: true})
+		a = joinHub(t, h, "a", false)
+		b = joinHub(t, h, "b", false)
+	)
+	defer h.Close()
+
+	want := "*** b joined\n"
+	if got := readHub(t, a, len(want)); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	b.Close()
+
+	want = "*** b left\n"
+	if got := readHub(t, a, len(want)); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	waitHubLen(t, h, 1)
+}
+
+// TestHubSlowMember checks that slow members are disconnected while
+// slow permanent members only lose messages.
+func TestHubSlowMember(t *testing.T) {
+	var (
+		h         = NewHub(HubOptions{Queue: 1})
+		sender    = joinHub(t, h, "sender", false)
+		slow      = joinHub(t, h, "slow", false)
+		permanent = joinHub(t, h, "permanent", true)
+	)
+	defer h.Close()
+
+	// Neither slow nor permanent are reading: one message is being
+	// written, one is queued and the third one does not fit.
+	for i := 0; i < 3; i++ {
+		if _, err := sender.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitHubLen(t, h, 2)
+
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if data, err := io.ReadAll(slow); err != nil || len(data) > 1 {
+		t.Fatalf("slow member was not disconnected: %q, %v", data, err)
+	}
+
+	// The permanent member catches up and receives new messages.
+	done := make(chan error, 1)
+	go func() {
+		var (
+			buf = make([]byte, 16)
+			got strings.Builder
+		)
+		permanent.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for !strings.Contains(got.String(), "last") {
+			n, err := permanent.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			got.Write(buf[:n])
+		}
+		done <- nil
+	}()
+
+	// Messages are dropped until the queue has been drained.
+	for {
+		if _, err := sender.Write([]byte("last")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("permanent member: %s", err)
+			}
+			if h.Len() != 2 {
+				t.Fatalf("hub has %d members; want 2", h.Len())
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -1,14 +1,53 @@
 package helper
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 )
 
+// MaxDatagramSize is the largest message which is passed on intact
+// by BidirectCopyDatagrams(); it covers UDP datagrams and IP packets.
+const MaxDatagramSize = 64 * 1024
+
+// ErrNotSupported is returned by wrappers whose connection lacks a
+// method, e.g. CloseWrite(); it is proxy.ErrNotSupported as well.
+var ErrNotSupported = errors.New("method not supported")
+
+// CloseWriter is implemented by connections which support
+// half-close, e.g. *net.TCPConn.
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite shuts down the writing direction of w if supported and
+// closes w entirely otherwise.
+func closeWrite(w io.Closer) {
+	if cw, ok := w.(CloseWriter); ok && cw.CloseWrite() == nil {
+		return
+	}
+	w.Close()
+}
+
+// finishCopy propagates the end of a copy to dst: a clean EOF is
+// passed on as half-close, such that the reverse direction keeps
+// running; errors close dst entirely.
+func finishCopy(dst io.Closer, err error) {
+	if err != nil {
+		dst.Close()
+		return
+	}
+	closeWrite(dst)
+}
+
 // BidirectCopy is a helper which spawns two goroutines.
 // Each goroutine copies data from left to right and right to
-// left respectively.
+// left respectively. The returned counters are the bytes copied
+// from left to right and from right to left, even on errors.
+// EOF on one side is propagated as half-close to the other side if
+// it implements CloseWriter; both sides are closed once both
+// directions have finished.
 func BidirectCopy(left io.ReadWriteCloser, right io.ReadWriteCloser) (int, int, error) {
 	var (
 		n1   = 0
@@ -22,31 +61,34 @@ func BidirectCopy(left io.ReadWriteCloser, right io.ReadWriteCloser) (int, int,
 	wg.Add(2)
 
 	go func() {
-		if n, err := io.Copy(right, left); err != nil {
+		n, err := io.Copy(right, left)
+		if err != nil {
 			err1 = err
-		} else {
-			n1 = int(n)
 		}
+		n1 = int(n)
 
-		right.Close()
+		finishCopy(right, err)
 		wg.Done()
 	}()
 
 	go func() {
-		if n, err := io.Copy(left, right); err != nil {
+		n, err := io.Copy(left, right)
+		if err != nil {
 			err2 = err
-		} else {
-			n2 = int(n)
 		}
+		n2 = int(n)
 
-		left.Close()
+		finishCopy(left, err)
 		wg.Done()
 	}()
 
 	wg.Wait()
 
+	left.Close()
+	right.Close()
+
 	if err1 != nil && err2 != nil {
-		err = fmt.Errorf("both copier failed; left: %s; right: %s", err1, err2)
+		err = fmt.Errorf("both copier failed; left: %w; right: %w", err1, err2)
 	} else {
 		if err1 != nil {
 			err = err1
@@ -0,0 +1,140 @@
+package helper
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+type copyResult struct {
+	n1, n2 int
+	err    error
+}
+
+func bidirectCopy(left, right io.ReadWriteCloser) <-chan copyResult {
+	ch := make(chan copyResult, 1)
+	go func() {
+		n1, n2, err := BidirectCopy(left, right)
+		ch <- copyResult{n1, n2, err}
+	}()
+	return ch
+}
+
+func readAll(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func waitCopy(t *testing.T, ch <-chan copyResult) copyResult {
+	t.Helper()
+
+	var r copyResult
+	select {
+	case r = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BidirectCopy did not return")
+	}
+	return r
+}
+
+func TestBidirectCopyHalfClose(t *testing.T) {
+	var (
+		leftPeer, left   = tcpPair(t)
+		rightPeer, right = tcpPair(t)
+		ch               = bidirectCopy(left, right)
+	)
+
+	if _, err := leftPeer.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := leftPeer.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The EOF arrives as half-close; the reverse direction is
+	// still open.
+	if got := readAll(t, rightPeer); got != "request" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := rightPeer.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	rightPeer.Close()
+
+	if got := readAll(t, leftPeer); got != "response" {
+		t.Fatalf("got %q", got)
+	}
+
+	r := waitCopy(t, ch)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.n1 != len("request") || r.n2 != len("response") {
+		t.Fatalf("unexpected counters: %d, %d", r.n1, r.n2)
+	}
+}
+
+func TestBidirectCopyWithoutCloseWrite(t *testing.T) {
+	pipe, pipePeer := net.Pipe()
+	defer pipePeer.Close()
+
+	var (
+		// eventConn implements CloseWriter, but net.Pipe() does
+		// not support half-close.
+		left             = &eventConn{Conn: pipe, service: "test", start: time.Now()}
+		rightPeer, right = tcpPair(t)
+		ch               = bidirectCopy(left, right)
+	)
+
+	if _, err := rightPeer.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rightPeer.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// left is closed entirely instead.
+	if got := readAll(t, pipePeer); got != "request" {
+		t.Fatalf("got %q", got)
+	}
+
+	waitCopy(t, ch)
+
+	if got := readAll(t, rightPeer); got != "" {
+		t.Fatalf("got %q", got)
+	}
+}
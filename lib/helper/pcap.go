@@ -0,0 +1,294 @@
+package helper
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pcapng block types and the link type of raw IP packets.
+const (
+	pcapngSectionHeader = 0x0a0d0d0a
+	pcapngInterfaceDesc = 0x00000001
+	pcapngEnhancedPkt   = 0x00000006
+	pcapngByteOrder     = 0x1a2b3c4d
+	linkTypeRaw         = 101
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	// Payload per synthetic segment; leaves room for the headers
+	// within the 16 bit IP length.
+	maxSegment = 65000
+)
+
+// PcapWriter writes the traffic of sessions as synthetic TCP
+// conversations to a pcapng file, e.g. for Wireshark. Every
+// conversation starts with a fake handshake and ends with a FIN in
+// each direction; the packets carry real timestamps.
+type PcapWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	count uint64
+}
+
+// NewPcapWriter writes the pcapng headers to w.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var (
+		le  = binary.LittleEndian
+		shb = make([]byte, 28)
+		idb = make([]byte, 20)
+	)
+
+	le.PutUint32(shb[0:], pcapngSectionHeader)
+	le.PutUint32(shb[4:], uint32(len(shb)))
+	le.PutUint32(shb[8:], pcapngByteOrder)
+	le.PutUint16(shb[12:], 1)          // major version
+	le.PutUint16(shb[14:], 0)          // minor version
+	le.PutUint64(shb[16:], ^uint64(0)) // unknown section length
+	le.PutUint32(shb[24:], uint32(len(shb)))
+
+	le.PutUint32(idb[0:], pcapngInterfaceDesc)
+	le.PutUint32(idb[4:], uint32(len(idb)))
+	le.PutUint16(idb[8:], linkTypeRaw)
+	le.PutUint32(idb[12:], 0) // no snap length
+	le.PutUint32(idb[16:], uint32(len(idb)))
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+
+	return &PcapWriter{w: w}, nil
+}
+
+// writePacket writes a packet with the default timestamp resolution
+// of microseconds; the caller must hold the mutex.
+func (pw *PcapWriter) writePacket(pkt []byte) error {
+	var (
+		le     = binary.LittleEndian
+		padded = (len(pkt) + 3) &^ 3
+		block  = make([]byte, 32+padded)
+		ts     = uint64(time.Now().UnixMicro())
+	)
+
+	le.PutUint32(block[0:], pcapngEnhancedPkt)
+	le.PutUint32(block[4:], uint32(len(block)))
+	le.PutUint32(block[8:], 0) // interface id
+	le.PutUint32(block[12:], uint32(ts>>32))
+	le.PutUint32(block[16:], uint32(ts))
+	le.PutUint32(block[20:], uint32(len(pkt)))
+	le.PutUint32(block[24:], uint32(len(pkt)))
+	copy(block[28:], pkt)
+	le.PutUint32(block[28+padded:], uint32(len(block)))
+
+	_, err := pw.w.Write(block)
+	return err
+}
+
+// endpoint converts addr to an IP address and a port; ok is false if
+// addr has none, e.g. stdio.
+func endpoint(addr net.Addr) (net.IP, uint16, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, uint16(a.Port), true
+	case *net.UDPAddr:
+		return a.IP, uint16(a.Port), true
+	case nil:
+		return nil, 0, false
+	}
+
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return nil, 0, false
+	}
+	return ip, uint16(port), true
+}
+
+// Wrap records the traffic of conn, which is the client side of a
+// session, as a conversation between client and server. Addresses
+// without IP, e.g. of stdio, are replaced with 127.0.0.1 and an
+// ephemeral port for the client and 127.0.0.2 for the server.
+func (pw *PcapWriter) Wrap(conn io.ReadWriteCloser, client, server net.Addr) io.ReadWriteCloser {
+	pw.mutex.Lock()
+	pw.count++
+	n := pw.count
+	pw.mutex.Unlock()
+
+	c := &pcapConversation{writer: pw}
+
+	var ok bool
+	if c.clientIP, c.clientPort, ok = endpoint(client); !ok {
+		c.clientIP = net.IPv4(127, 0, 0, 1)
+		c.clientPort = uint16(49152 + n%16384)
+	}
+	if c.serverIP, c.serverPort, ok = endpoint(server); !ok {
+		c.serverIP = net.IPv4(127, 0, 0, 2)
+		c.serverPort = 9
+	}
+
+	// Both ends need the same address family.
+	if c.clientIP.To4() == nil || c.serverIP.To4() == nil {
+		c.clientIP = c.clientIP.To16()
+		c.serverIP = c.serverIP.To16()
+	} else {
+		c.clientIP = c.clientIP.To4()
+		c.serverIP = c.serverIP.To4()
+	}
+
+	c.handshake()
+
+	return &tapConn{ReadWriteCloser: conn, tap: c}
+}
+
+// pcapConversation tracks the sequence numbers of a synthetic TCP
+// connection; it is protected by the mutex of the writer.
+type pcapConversation struct {
+	writer *PcapWriter
+
+	clientIP, serverIP     net.IP
+	clientPort, serverPort uint16
+	clientSeq, serverSeq   uint32
+	clientFin, serverFin   bool
+	// The first error of the writer disables the conversation.
+	failed bool
+}
+
+func (c *pcapConversation) handshake() {
+	c.writer.mutex.Lock()
+	defer c.writer.mutex.Unlock()
+
+	c.clientSeq = 1000
+	c.serverSeq = 5000
+
+	c.segment(true, tcpSYN, nil)
+	c.clientSeq++
+	c.segment(false, tcpSYN|tcpACK, nil)
+	c.serverSeq++
+	c.segment(true, tcpACK, nil)
+}
+
+func (c *pcapConversation) data(out bool, p []byte) {
+	c.writer.mutex.Lock()
+	defer c.writer.mutex.Unlock()
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxSegment)]
+		p = p[len(chunk):]
+
+		c.segment(out, tcpPSH|tcpACK, chunk)
+		if out {
+			c.clientSeq += uint32(len(chunk))
+		} else {
+			c.serverSeq += uint32(len(chunk))
+		}
+	}
+}
+
+func (c *pcapConversation) fin(out bool) {
+	c.writer.mutex.Lock()
+	defer c.writer.mutex.Unlock()
+
+	if out && !c.clientFin {
+		c.clientFin = true
+		c.segment(true, tcpFIN|tcpACK, nil)
+		c.clientSeq++
+	} else if !out && !c.serverFin {
+		c.serverFin = true
+		c.segment(false, tcpFIN|tcpACK, nil)
+		c.serverSeq++
+	}
+}
+
+// segment writes a TCP segment from the client if out is set and
+// from the server otherwise.
+func (c *pcapConversation) segment(out bool, flags byte, payload []byte) {
+	if c.failed {
+		return
+	}
+
+	var (
+		srcIP, dstIP     = c.clientIP, c.serverIP
+		srcPort, dstPort = c.clientPort, c.serverPort
+		seq, ack         = c.clientSeq, c.serverSeq
+	)
+	if !out {
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = ack, seq
+	}
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	be := binary.BigEndian
+	tcp := make([]byte, 20+len(payload))
+	be.PutUint16(tcp[0:], srcPort)
+	be.PutUint16(tcp[2:], dstPort)
+	be.PutUint32(tcp[4:], seq)
+	be.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // header length in words
+	tcp[13] = flags
+	be.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[20:], payload)
+
+	var pkt []byte
+	if len(srcIP) == net.IPv4len {
+		pkt = make([]byte, 20, 20+len(tcp))
+		pkt[0] = 0x45 // version 4, header length 5 words
+		be.PutUint16(pkt[2:], uint16(20+len(tcp)))
+		pkt[8] = 64 // ttl
+		pkt[9] = 6  // tcp
+		copy(pkt[12:], srcIP)
+		copy(pkt[16:], dstIP)
+		be.PutUint16(pkt[10:], ^checksum(0, pkt))
+	} else {
+		pkt = make([]byte, 40, 40+len(tcp))
+		pkt[0] = 0x60 // version 6
+		be.PutUint16(pkt[4:], uint16(len(tcp)))
+		pkt[6] = 6  // tcp
+		pkt[7] = 64 // hop limit
+		copy(pkt[8:], srcIP)
+		copy(pkt[24:], dstIP)
+	}
+
+	// The pseudo header of IPv4 and IPv6 sums up to the same value
+	// for lengths below 64 KiB.
+	pseudo := make([]byte, 0, 2*net.IPv6len+4)
+	pseudo = append(pseudo, srcIP...)
+	pseudo = append(pseudo, dstIP...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = be.AppendUint16(pseudo, uint16(len(tcp)))
+	be.PutUint16(tcp[16:], ^checksum(checksum(0, pseudo), tcp))
+
+	if err := c.writer.writePacket(append(pkt, tcp...)); err != nil {
+		c.failed = true
+	}
+}
+
+// checksum adds data to the ones' complement sum of the internet
+// checksum.
+func checksum(sum uint16, data []byte) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return uint16(s)
+}
@@ -0,0 +1,134 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+const (
+	// Section header and interface description block.
+	pcapHeaderGolden = "0a0d0d0a1c0000004d3c2b1a01000000ffffffffffffffff1c000000" +
+		"0100000014000000650000000000000014000000"
+	// SYN from 10.0.0.1:40000 to 10.0.0.2:80.
+	pcapSYNGolden = "4500002800000000400666ce0a0000010a000002" +
+		"9c400050000003e8000000005002fffffb670000"
+)
+
+// pcapPackets splits the enhanced packet blocks of a pcapng file
+// without its headers into the packets.
+func pcapPackets(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+
+	var (
+		le   = binary.LittleEndian
+		pkts [][]byte
+	)
+	for len(b) > 0 {
+		if len(b) < 32 {
+			t.Fatalf("truncated block: %x", b)
+		}
+		length := int(le.Uint32(b[4:]))
+		if typ := le.Uint32(b[0:]); typ != pcapngEnhancedPkt {
+			t.Fatalf("got block type %#x", typ)
+		}
+		if length%4 != 0 || length > len(b) || int(le.Uint32(b[length-4:])) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		captured := int(le.Uint32(b[20:]))
+		if captured != int(le.Uint32(b[24:])) || 32+captured > length {
+			t.Fatalf("invalid packet length %d", captured)
+		}
+		pkts = append(pkts, b[28:28+captured])
+		b = b[length:]
+	}
+	return pkts
+}
+
+// segmentSummary describes the TCP segment of an IPv4 packet and
+// checks its checksums.
+func segmentSummary(t *testing.T, pkt []byte) string {
+	t.Helper()
+
+	be := binary.BigEndian
+	if checksum(0, pkt[:20]) != 0xffff {
+		t.Fatalf("invalid IP checksum: %x", pkt)
+	}
+	if int(be.Uint16(pkt[2:])) != len(pkt) {
+		t.Fatalf("invalid IP length: %x", pkt)
+	}
+
+	tcp := pkt[20:]
+	pseudo := append(append([]byte{}, pkt[12:20]...), 0, 6)
+	pseudo = be.AppendUint16(pseudo, uint16(len(tcp)))
+	if checksum(checksum(0, pseudo), tcp) != 0xffff {
+		t.Fatalf("invalid TCP checksum: %x", pkt)
+	}
+
+	flags := ""
+	for _, f := range []struct {
+		bit  byte
+		name string
+	}{{tcpSYN, "S"}, {tcpFIN, "F"}, {tcpPSH, "P"}, {tcpACK, "."}} {
+		if tcp[13]&f.bit != 0 {
+			flags += f.name
+		}
+	}
+
+	return fmt.Sprintf("%s:%d > %s:%d [%s] seq=%d ack=%d %q",
+		net.IP(pkt[12:16]), be.Uint16(tcp[0:]),
+		net.IP(pkt[16:20]), be.Uint16(tcp[2:]),
+		flags, be.Uint32(tcp[4:]), be.Uint32(tcp[8:]), tcp[20:])
+}
+
+func TestPcapWriter(t *testing.T) {
+	var out bytes.Buffer
+	pw, err := NewPcapWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(out.Bytes()); got != pcapHeaderGolden {
+		t.Fatalf("got header %s", got)
+	}
+	out.Reset()
+
+	var (
+		client = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+		server = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80}
+		conn   = pw.Wrap(&bufferConn{Reader: bytes.NewBufferString("hi"), Writer: io.Discard}, client, server)
+	)
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	want := []string{
+		`10.0.0.1:40000 > 10.0.0.2:80 [S] seq=1000 ack=0 ""`,
+		`10.0.0.2:80 > 10.0.0.1:40000 [S.] seq=5000 ack=1001 ""`,
+		`10.0.0.1:40000 > 10.0.0.2:80 [.] seq=1001 ack=5001 ""`,
+		`10.0.0.1:40000 > 10.0.0.2:80 [P.] seq=1001 ack=5001 "hi"`,
+		`10.0.0.1:40000 > 10.0.0.2:80 [F.] seq=1003 ack=5001 ""`,
+		`10.0.0.2:80 > 10.0.0.1:40000 [P.] seq=5001 ack=1004 "hello"`,
+		`10.0.0.2:80 > 10.0.0.1:40000 [F.] seq=5006 ack=1004 ""`,
+	}
+
+	pkts := pcapPackets(t, out.Bytes())
+	if len(pkts) != len(want) {
+		t.Fatalf("got %d packets; want %d", len(pkts), len(want))
+	}
+	if got := hex.EncodeToString(pkts[0]); got != pcapSYNGolden {
+		t.Fatalf("got SYN %s", got)
+	}
+	for i, pkt := range pkts {
+		if got := segmentSummary(t, pkt); got != want[i] {
+			t.Errorf("packet %d: got %s; want %s", i, got, want[i])
+		}
+	}
+}
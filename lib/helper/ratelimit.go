@@ -0,0 +1,180 @@
+package helper
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket which limits a data stream to a
+// number of bytes per second. A limiter can be shared by several
+// streams, e.g. to limit all sessions together.
+type RateLimiter struct {
+	rate  float64
+	burst int
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter limits to rate bytes per second; bursts are limited
+// to a tenth of a second but to at least 1500 bytes, such that slow
+// links still carry full packets.
+func NewRateLimiter(rate int64) *RateLimiter {
+	burst := max(int(rate/10), 1500)
+	return &RateLimiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Burst is the maximum chunk size which passes without delay.
+func (l *RateLimiter) Burst() int {
+	return l.burst
+}
+
+// reserve takes n tokens and returns the time to wait until they
+// are available. The bucket might go into debt; subsequent callers
+// wait for it to be paid off.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.tokens = min(l.tokens, float64(l.burst))
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes may pass or done is closed.
+func (l *RateLimiter) Wait(n int, done <-chan struct{}) error {
+	delay := l.reserve(n)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return net.ErrClosed
+	}
+}
+
+// RateLimit limits the traffic of conn, which is the client side of
+// a session: reads pass all read limiters and writes all write
+// limiters. Nil limiters are ignored.
+func RateLimit(conn io.ReadWriteCloser, read, write []*RateLimiter) io.ReadWriteCloser {
+	c := &rateConn{ReadWriteCloser: conn, done: make(chan struct{})}
+	for _, l := range read {
+		if l != nil {
+			c.read = append(c.read, l)
+		}
+	}
+	for _, l := range write {
+		if l != nil {
+			c.write = append(c.write, l)
+		}
+	}
+	return c
+}
+
+// RateLimitDatagrams is RateLimit() for connections which preserve
+// message boundaries; messages are delayed as a whole instead of
+// being split into bursts.
+func RateLimitDatagrams(conn io.ReadWriteCloser, read, write []*RateLimiter) io.ReadWriteCloser {
+	c := RateLimit(conn, read, write).(*rateConn)
+	c.datagrams = true
+	return c
+}
+
+type rateConn struct {
+	io.ReadWriteCloser
+	read      []*RateLimiter
+	write     []*RateLimiter
+	datagrams bool
+
+	once sync.Once
+	done chan struct{}
+}
+
+// chunk returns the smallest burst of limiters; larger chunks would
+// be delayed as a whole. Datagrams are never split.
+func (c *rateConn) chunk(limiters []*RateLimiter, n int) int {
+	if c.datagrams {
+		return n
+	}
+	for _, l := range limiters {
+		n = min(n, l.Burst())
+	}
+	return n
+}
+
+func (c *rateConn) wait(limiters []*RateLimiter, n int) error {
+	for _, l := range limiters {
+		if err := l.Wait(n, c.done); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *rateConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p[:c.chunk(c.read, len(p))])
+	if n > 0 {
+		if werr := c.wait(c.read, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (c *rateConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := c.chunk(c.write, len(p))
+		if err := c.wait(c.write, size); err != nil {
+			return written, err
+		}
+
+		n, err := c.ReadWriteCloser.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (c *rateConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.ReadWriteCloser.Close()
+}
+
+func (c *rateConn) CloseWrite() error {
+	if cw, ok := c.ReadWriteCloser.(CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNotSupported
+}
+
+func (c *rateConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrNotSupported
+}
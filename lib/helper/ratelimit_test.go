@@ -0,0 +1,134 @@
+package helper
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeRecorder records the sizes of the writes.
+type writeRecorder struct {
+	mutex sync.Mutex
+	sizes []int
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.sizes = append(w.sizes, len(p))
+	return len(p), nil
+}
+
+func within(d, want time.Duration) bool {
+	return d > want-20*time.Millisecond && d <= want
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(10000)
+	if l.Burst() != 1500 {
+		t.Fatalf("got burst %d", l.Burst())
+	}
+
+	// The burst passes right away, the rest goes into debt which
+	// later callers pay off as well.
+	if d := l.reserve(1500); d != 0 {
+		t.Fatalf("burst delayed by %s", d)
+	}
+	if d := l.reserve(1000); !within(d, 100*time.Millisecond) {
+		t.Fatalf("got delay %s; want 100ms", d)
+	}
+	if d := l.reserve(500); !within(d, 150*time.Millisecond) {
+		t.Fatalf("got delay %s; want 150ms", d)
+	}
+
+	// Idle time refills the bucket up to the burst only.
+	l = NewRateLimiter(100000)
+	l.last = l.last.Add(-time.Hour)
+	if d := l.reserve(l.Burst()); d != 0 {
+		t.Fatalf("burst delayed by %s", d)
+	}
+	if d := l.reserve(10000); !within(d, 100*time.Millisecond) {
+		t.Fatalf("got delay %s; want 100ms", d)
+	}
+}
+
+func TestRateLimitShared(t *testing.T) {
+	var (
+		total = NewRateLimiter(100000)
+		start = time.Now()
+		wg    sync.WaitGroup
+	)
+
+	// Both sessions share 100 kB/s; 30 kB take 200ms after the
+	// burst of 10 kB.
+	for i := 0; i < 2; i++ {
+		conn := RateLimit(&bufferConn{Writer: io.Discard}, nil, []*RateLimiter{total})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Write(make([]byte, 15000)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if d := time.Since(start); d < 180*time.Millisecond {
+		t.Fatalf("took %s; want 200ms", d)
+	}
+}
+
+func TestRateLimitDatagrams(t *testing.T) {
+	for _, datagrams := range []bool{false, true} {
+		var (
+			w       = &writeRecorder{}
+			limiter = NewRateLimiter(15000)
+			conn    io.ReadWriteCloser
+		)
+		if datagrams {
+			conn = RateLimitDatagrams(&bufferConn{Writer: w}, nil, []*RateLimiter{limiter})
+		} else {
+			conn = RateLimit(&bufferConn{Writer: w}, nil, []*RateLimiter{limiter})
+		}
+
+		if _, err := conn.Write(make([]byte, 4000)); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []int{1500, 1500, 1000}
+		if datagrams {
+			want = []int{4000}
+		}
+		if len(w.sizes) != len(want) {
+			t.Fatalf("datagrams=%v: got writes %v; want %v", datagrams, w.sizes, want)
+		}
+		for i := range want {
+			if w.sizes[i] != want[i] {
+				t.Fatalf("datagrams=%v: got writes %v; want %v", datagrams, w.sizes, want)
+			}
+		}
+	}
+}
+
+func TestRateLimitClose(t *testing.T) {
+	var (
+		limiter = NewRateLimiter(1000)
+		conn    = RateLimit(&bufferConn{Writer: io.Discard}, nil, []*RateLimiter{limiter})
+	)
+
+	if err := conn.(CloseWriter).CloseWrite(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("got error %v; want %v", err, ErrNotSupported)
+	}
+
+	// Close() ends waiting for the limiter.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+	}()
+	if _, err := conn.Write(make([]byte, 10000)); err == nil {
+		t.Fatal("write was not interrupted")
+	}
+}
@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// tap observes the traffic of the client side of a session. out is
+// true for data from the client and false for data to the client.
+type tap interface {
+	data(out bool, p []byte)
+	// fin is called once a direction has ended.
+	fin(out bool)
+}
+
+// tapConn passes the traffic of the wrapped connection to a tap.
+type tapConn struct {
+	io.ReadWriteCloser
+	tap tap
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.tap.data(true, p[:n])
+	}
+	if errors.Is(err, io.EOF) {
+		c.tap.fin(true)
+	}
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.tap.data(false, p[:n])
+	}
+	return n, err
+}
+
+func (c *tapConn) CloseWrite() error {
+	c.tap.fin(false)
+
+	if cw, ok := c.ReadWriteCloser.(CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("half-close not supported")
+}
+
+func (c *tapConn) Close() error {
+	c.tap.fin(true)
+	c.tap.fin(false)
+
+	return c.ReadWriteCloser.Close()
+}
+
+func (c *tapConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return fmt.Errorf("deadlines not supported")
+}
@@ -0,0 +1,381 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TeePolicy decides what happens to a destination which cannot
+// keep up with the source.
+type TeePolicy string
+
+const (
+	// TeeBlock waits for the destination; this slows down the
+	// source and thus all other destinations as well.
+	TeeBlock TeePolicy = "block"
+	// TeeDrop discards data while the destination's queue is full.
+	TeeDrop TeePolicy = "drop"
+	// TeeClose disconnects the destination once its queue is full.
+	TeeClose TeePolicy = "close"
+)
+
+var (
+	ErrSlowDestination = errors.New("destination too slow")
+	ErrNoDestinations  = errors.New("all destinations failed")
+
+	errTeeReadDeadline = errors.New("read deadlines not supported")
+)
+
+type TeeOptions struct {
+	Policy TeePolicy
+	// Queue is the number of writes buffered per destination.
+	Queue int
+	// Merge passes the replies of all destinations to Read().
+	// Otherwise replies are discarded.
+	Merge bool
+	// FailFast fails the whole tee as soon as one destination
+	// fails; otherwise it continues as long as one is left.
+	FailFast bool
+	// OnError is called once for every failed destination.
+	OnError func(i int, err error)
+}
+
+// TeeStats is the accounting of a single destination.
+type TeeStats struct {
+	Written int64
+	Dropped int64
+	Err     error
+}
+
+type teeDest struct {
+	conn  net.Conn
+	queue chan []byte
+	dead  chan struct{}
+	stats TeeStats
+}
+
+// Tee is a net.Conn which broadcasts writes to several destination
+// connections. Reads return the merged replies of the destinations
+// if enabled and io.EOF once all destinations are gone. The address
+// methods refer to the first destination.
+type Tee struct {
+	opts    TeeOptions
+	dests   []*teeDest
+	replies chan []byte
+	pending []byte
+	// done is closed once writing has stopped, closed by Close().
+	done      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	writers   sync.WaitGroup
+
+	mutex   sync.Mutex
+	closing bool
+	alive   int
+	err     error
+}
+
+func NewTee(conns []net.Conn, opts TeeOptions) *Tee {
+	if opts.Policy == "" {
+		opts.Policy = TeeBlock
+	}
+	if opts.Queue <= 0 {
+		opts.Queue = 64
+	}
+
+	t := &Tee{
+		opts:    opts,
+		replies: make(chan []byte),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+		alive:   len(conns),
+	}
+
+	var readers sync.WaitGroup
+
+	for i, conn := range conns {
+		d := &teeDest{
+			conn:  conn,
+			queue: make(chan []byte, opts.Queue),
+			dead:  make(chan struct{}),
+		}
+		t.dests = append(t.dests, d)
+
+		t.writers.Add(1)
+		readers.Add(1)
+
+		go t.write(i, d)
+		go func(i int, d *teeDest) {
+			t.read(i, d)
+			readers.Done()
+		}(i, d)
+	}
+
+	go func() {
+		readers.Wait()
+		close(t.replies)
+	}()
+
+	return t
+}
+
+func (t *Tee) write(i int, d *teeDest) {
+	defer t.writers.Done()
+
+	for {
+		select {
+		case b := <-d.queue:
+			t.send(i, d, b)
+		case <-d.dead:
+			return
+		case <-t.done:
+			// Flush what is queued already.
+			for {
+				select {
+				case b := <-d.queue:
+					t.send(i, d, b)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *Tee) send(i int, d *teeDest, b []byte) {
+	select {
+	case <-d.dead:
+		return
+	default:
+	}
+
+	n, err := d.conn.Write(b)
+
+	t.mutex.Lock()
+	d.stats.Written += int64(n)
+	t.mutex.Unlock()
+
+	if err != nil {
+		t.fail(i, err)
+	}
+}
+
+func (t *Tee) read(i int, d *teeDest) {
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := d.conn.Read(buf)
+		if n > 0 && t.opts.Merge {
+			select {
+			case t.replies <- bytes.Clone(buf[:n]):
+			case <-d.dead:
+				return
+			case <-t.closed:
+				return
+			}
+		}
+		if err != nil {
+			// A destination which hangs up is gone as well.
+			t.fail(i, err)
+			return
+		}
+	}
+}
+
+// fail takes destination i out of the tee.
+func (t *Tee) fail(i int, err error) {
+	d := t.dests[i]
+
+	t.mutex.Lock()
+	select {
+	case <-d.dead:
+		t.mutex.Unlock()
+		return
+	default:
+	}
+
+	close(d.dead)
+	t.alive--
+
+	// Errors caused by Close() or by a previous abort are not
+	// worth reporting.
+	report := !t.closing && t.err == nil
+	if report {
+		d.stats.Err = err
+		if t.opts.FailFast {
+			t.err = err
+		}
+	}
+	abort := report && t.opts.FailFast
+	t.mutex.Unlock()
+
+	d.conn.Close()
+
+	if report && t.opts.OnError != nil {
+		t.opts.OnError(i, err)
+	}
+	if abort {
+		t.closeConns()
+	}
+}
+
+func (t *Tee) closeConns() {
+	for _, d := range t.dests {
+		d.conn.Close()
+	}
+}
+
+func (t *Tee) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	switch {
+	case t.closing:
+		t.mutex.Unlock()
+		return 0, net.ErrClosed
+	case t.err != nil:
+		err := t.err
+		t.mutex.Unlock()
+		return 0, err
+	case t.alive == 0:
+		t.mutex.Unlock()
+		return 0, ErrNoDestinations
+	}
+	t.mutex.Unlock()
+
+	// The queues keep the buffer beyond this call.
+	b := bytes.Clone(p)
+
+	for i, d := range t.dests {
+		switch t.opts.Policy {
+		case TeeDrop:
+			select {
+			case d.queue <- b:
+			case <-d.dead:
+			default:
+				t.mutex.Lock()
+				d.stats.Dropped += int64(len(b))
+				t.mutex.Unlock()
+			}
+		case TeeClose:
+			select {
+			case d.queue <- b:
+			case <-d.dead:
+			default:
+				t.fail(i, ErrSlowDestination)
+			}
+		default:
+			select {
+			case d.queue <- b:
+			case <-d.dead:
+			case <-t.done:
+				return 0, net.ErrClosed
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+func (t *Tee) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		b, ok := <-t.replies
+		if !ok {
+			return 0, io.EOF
+		}
+		t.pending = b
+	}
+
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+
+	return n, nil
+}
+
+// stopWriting flushes the queued data; it reports false if writing
+// has been stopped already.
+func (t *Tee) stopWriting() bool {
+	t.mutex.Lock()
+	if t.closing {
+		t.mutex.Unlock()
+		return false
+	}
+	t.closing = true
+	close(t.done)
+	t.mutex.Unlock()
+
+	t.writers.Wait()
+
+	return true
+}
+
+// CloseWrite flushes the queued data and half-closes the destinations
+// where supported; the replies can still be read.
+func (t *Tee) CloseWrite() error {
+	if !t.stopWriting() {
+		return net.ErrClosed
+	}
+	for _, d := range t.dests {
+		closeWrite(d.conn)
+	}
+	return nil
+}
+
+// Close flushes the queued data and closes all destinations.
+// A concurrent second call aborts the flush, e.g. when a stalled
+// destination blocks it.
+func (t *Tee) Close() error {
+	t.stopWriting()
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	t.closeConns()
+
+	return nil
+}
+
+// Stats returns the accounting of every destination.
+func (t *Tee) Stats() []TeeStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	out := make([]TeeStats, 0, len(t.dests))
+	for _, d := range t.dests {
+		out = append(out, d.stats)
+	}
+	return out
+}
+
+func (t *Tee) LocalAddr() net.Addr {
+	return t.dests[0].conn.LocalAddr()
+}
+
+func (t *Tee) RemoteAddr() net.Addr {
+	return t.dests[0].conn.RemoteAddr()
+}
+
+// SetDeadline only supports write deadlines, see SetReadDeadline().
+func (t *Tee) SetDeadline(deadline time.Time) error {
+	if err := t.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return errTeeReadDeadline
+}
+
+// SetReadDeadline is not supported: the destinations are read by
+// background goroutines, which would take an expired deadline as
+// failure of the destination. Callers such as BidirectCopyTimeout()
+// fall back to closing the tee instead.
+func (t *Tee) SetReadDeadline(deadline time.Time) error {
+	return errTeeReadDeadline
+}
+
+func (t *Tee) SetWriteDeadline(deadline time.Time) error {
+	var errs []error
+	for _, d := range t.dests {
+		errs = append(errs, d.conn.SetWriteDeadline(deadline))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTee(t *testing.T) {
+	var (
+		conns []net.Conn
+		peers []net.Conn
+	)
+	for i := 0; i < 2; i++ {
+		conn, peer := net.Pipe()
+		conns = append(conns, conn)
+		peers = append(peers, peer)
+	}
+
+	tee := NewTee(conns, TeeOptions{Merge: true})
+
+	if _, err := tee.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, peer := range peers {
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(peer, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "hello" {
+			t.Fatalf("destination %d: got %q", i, buf)
+		}
+	}
+
+	// The destination which hangs up is removed; the other one
+	// keeps receiving data.
+	peers[0].Close()
+	go peers[1].Write([]byte("reply"))
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(tee, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "reply" {
+		t.Fatalf("got merged reply %q", buf)
+	}
+
+	if _, err := tee.Write([]byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(peers[1], buf); err != nil {
+		t.Fatal(err)
+	}
+
+	peers[1].Close()
+	if _, err := tee.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("got error %v; expected EOF", err)
+	}
+	if _, err := tee.Write([]byte("gone")); !errors.Is(err, ErrNoDestinations) {
+		t.Fatalf("got error %v; expected %v", err, ErrNoDestinations)
+	}
+
+	tee.Close()
+}
+
+// TestTeeIdleTimeout sends data in one direction only; the tee must
+// not lose its destinations to the idle timeout while data flows.
+func TestTeeIdleTimeout(t *testing.T) {
+	var (
+		left, client = net.Pipe()
+		conn, peer   = net.Pipe()
+		tee          = NewTee([]net.Conn{conn}, TeeOptions{Merge: true})
+		result       = make(chan error, 1)
+	)
+	defer client.Close()
+	defer peer.Close()
+
+	go func() {
+		_, _, err := BidirectCopyTimeout(left, tee, CopyTimeouts{Idle: 100 * time.Millisecond})
+		result <- err
+	}()
+	go io.Copy(io.Discard, peer)
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	if err := tee.Stats()[0].Err; err != nil {
+		t.Fatalf("destination failed: %s", err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Fatalf("got %v; expected %v", err, ErrIdleTimeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle timeout did not fire")
+	}
+}
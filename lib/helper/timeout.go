@@ -0,0 +1,290 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrIdleTimeout      = errors.New("idle timeout")
+	ErrLifetimeExceeded = errors.New("session lifetime exceeded")
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+)
+
+// CopyTimeouts limit a BidirectCopyTimeout(); zero values disable
+// the respective limit.
+type CopyTimeouts struct {
+	// Idle is the maximum time without data in either direction.
+	Idle time.Duration
+	// Lifetime is the maximum duration of the whole copy.
+	Lifetime time.Duration
+}
+
+func (t CopyTimeouts) enabled() bool {
+	return t.Idle > 0 || t.Lifetime > 0
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// supportsDeadline probes whether rw supports read deadlines; stubs
+// such as proxy.BaseConn return an error.
+func supportsDeadline(rw io.ReadWriteCloser) bool {
+	d, ok := rw.(readDeadliner)
+	return ok && d.SetReadDeadline(time.Time{}) == nil
+}
+
+// timeoutCopier enforces CopyTimeouts with read deadlines if both
+// sides support them. Otherwise, a watchdog closes both sides.
+type timeoutCopier struct {
+	timeouts CopyTimeouts
+	bufSize  int
+	start    time.Time
+	last     atomic.Int64 // unix nanoseconds of the last activity
+
+	mutex  sync.Mutex
+	reason error
+}
+
+func (c *timeoutCopier) touch() {
+	c.last.Store(time.Now().UnixNano())
+}
+
+func (c *timeoutCopier) setReason(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.reason == nil {
+		c.reason = err
+	}
+}
+
+func (c *timeoutCopier) getReason() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.reason
+}
+
+// expired returns the reason if a limit is exceeded at now.
+func (c *timeoutCopier) expired(now time.Time) error {
+	if c.timeouts.Lifetime > 0 && now.Sub(c.start) >= c.timeouts.Lifetime {
+		return ErrLifetimeExceeded
+	}
+	if c.timeouts.Idle > 0 && now.Sub(time.Unix(0, c.last.Load())) >= c.timeouts.Idle {
+		return ErrIdleTimeout
+	}
+	return nil
+}
+
+// nextDeadline returns the point in time when the next limit might
+// be exceeded.
+func (c *timeoutCopier) nextDeadline() time.Time {
+	var deadline time.Time
+	if c.timeouts.Idle > 0 {
+		deadline = time.Unix(0, c.last.Load()).Add(c.timeouts.Idle)
+	}
+	if c.timeouts.Lifetime > 0 {
+		end := c.start.Add(c.timeouts.Lifetime)
+		if deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+	return deadline
+}
+
+// copy is io.Copy which tracks activity. If src supports deadlines,
+// expired read deadlines are checked against the activity of both
+// directions and are renewed if the copy is still alive. Every Read()
+// is passed on as one Write(), such that datagrams stay intact.
+func (c *timeoutCopier) copy(dst io.Writer, src io.Reader, useDeadline bool) (int64, error) {
+	var (
+		buf     = make([]byte, c.bufSize)
+		written int64
+	)
+
+	if useDeadline {
+		src.(readDeadliner).SetReadDeadline(c.nextDeadline())
+	}
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			c.touch()
+
+			nw, werr := dst.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if err != nil {
+			if useDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+				if reason := c.expired(time.Now()); reason != nil {
+					c.setReason(reason)
+					return written, reason
+				}
+				// The other direction was active meanwhile.
+				src.(readDeadliner).SetReadDeadline(c.nextDeadline())
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return written, nil
+			}
+			return written, err
+		}
+	}
+}
+
+// watchdog closes both sides once a limit is exceeded or done
+// is closed.
+func (c *timeoutCopier) watchdog(left, right io.Closer, done <-chan struct{}) {
+	interval := c.timeouts.Idle / 4
+	if interval <= 0 || (c.timeouts.Lifetime > 0 && c.timeouts.Lifetime < interval) {
+		interval = c.timeouts.Lifetime
+	}
+	interval = max(interval, 10*time.Millisecond)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if reason := c.expired(now); reason != nil {
+				c.setReason(reason)
+				left.Close()
+				right.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// BidirectCopyTimeout is BidirectCopy() with idle and lifetime
+// limits; a half-closed session counts as idle if no data flows in
+// the remaining direction. If a limit is exceeded, both sides are
+// closed and the returned error wraps ErrIdleTimeout or
+// ErrLifetimeExceeded.
+func BidirectCopyTimeout(left io.ReadWriteCloser, right io.ReadWriteCloser, timeouts CopyTimeouts) (int, int, error) {
+	if !timeouts.enabled() {
+		return BidirectCopy(left, right)
+	}
+	return newTimeoutCopier(timeouts, 32*1024).run(left, right)
+}
+
+// BidirectCopyDatagrams is BidirectCopyTimeout() for connections
+// which preserve message boundaries: each message read from one
+// side is written as one message to the other side. Messages must
+// not exceed MaxDatagramSize.
+func BidirectCopyDatagrams(left io.ReadWriteCloser, right io.ReadWriteCloser, timeouts CopyTimeouts) (int, int, error) {
+	return newTimeoutCopier(timeouts, MaxDatagramSize).run(left, right)
+}
+
+func newTimeoutCopier(timeouts CopyTimeouts, bufSize int) *timeoutCopier {
+	return &timeoutCopier{
+		timeouts: timeouts,
+		bufSize:  bufSize,
+		start:    time.Now(),
+	}
+}
+
+func (c *timeoutCopier) run(left io.ReadWriteCloser, right io.ReadWriteCloser) (int, int, error) {
+	var (
+		enabled     = c.timeouts.enabled()
+		useDeadline = enabled && supportsDeadline(left) && supportsDeadline(right)
+		done        = make(chan struct{})
+		n1, n2      int64
+		err1, err2  error
+		wg          sync.WaitGroup
+	)
+
+	c.touch()
+
+	if enabled && !useDeadline {
+		go c.watchdog(left, right, done)
+	}
+
+	wg.Add(2)
+
+	go func() {
+		n1, err1 = c.copy(right, left, useDeadline)
+		finishCopy(right, err1)
+		wg.Done()
+	}()
+
+	go func() {
+		n2, err2 = c.copy(left, right, useDeadline)
+		finishCopy(left, err2)
+		wg.Done()
+	}()
+
+	wg.Wait()
+	close(done)
+
+	left.Close()
+	right.Close()
+
+	// The errors of the copiers are consequences of the timeout.
+	if reason := c.getReason(); reason != nil {
+		return int(n1), int(n2), reason
+	}
+
+	var err error
+	if err1 != nil && err2 != nil {
+		err = fmt.Errorf("both copier failed; left: %w; right: %w", err1, err2)
+	} else if err1 != nil {
+		err = err1
+	} else if err2 != nil {
+		err = err2
+	}
+
+	return int(n1), int(n2), err
+}
+
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// StartHandshakeTimer limits the handshake on rw to timeout; a timeout
+// of 0 disables the limit. It sets a deadline if rw supports it and
+// closes rw otherwise. The returned function ends the limit; it
+// returns ErrHandshakeTimeout if the limit was hit.
+func StartHandshakeTimer(rw io.ReadWriteCloser, timeout time.Duration) func() error {
+	if timeout <= 0 {
+		return func() error { return nil }
+	}
+
+	if d, ok := rw.(deadliner); ok && d.SetDeadline(time.Now().Add(timeout)) == nil {
+		start := time.Now()
+		return func() error {
+			if time.Since(start) >= timeout {
+				return ErrHandshakeTimeout
+			}
+			return d.SetDeadline(time.Time{})
+		}
+	}
+
+	var fired atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		fired.Store(true)
+		rw.Close()
+	})
+	return func() error {
+		if !timer.Stop() && fired.Load() {
+			return ErrHandshakeTimeout
+		}
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// noDeadlineConn hides the deadline methods of its connection, such
+// that the watchdog is used instead.
+type noDeadlineConn struct {
+	io.ReadWriteCloser
+}
+
+func TestBidirectCopyTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts CopyTimeouts
+		// Data is sent in one direction for this long.
+		active time.Duration
+		// The copy must not return earlier.
+		min  time.Duration
+		want error
+	}{
+		{"idle", CopyTimeouts{Idle: 100 * time.Millisecond}, 0, 100 * time.Millisecond, ErrIdleTimeout},
+		{"idle after activity", CopyTimeouts{Idle: 100 * time.Millisecond}, 300 * time.Millisecond, 350 * time.Millisecond, ErrIdleTimeout},
+		{"lifetime", CopyTimeouts{Idle: time.Hour, Lifetime: 200 * time.Millisecond}, time.Hour, 200 * time.Millisecond, ErrLifetimeExceeded},
+	}
+
+	for _, tt := range tests {
+		for _, watchdog := range []bool{false, true} {
+			var (
+				leftPeer, left   = tcpPair(t)
+				rightPeer, right = tcpPair(t)
+				ch               = make(chan copyResult, 1)
+				start            = time.Now()
+				timeout          = time.After(5 * time.Second)
+			)
+			go func() {
+				var l, r io.ReadWriteCloser = left, right
+				if watchdog {
+					l, r = noDeadlineConn{left}, noDeadlineConn{right}
+				}
+				n1, n2, err := BidirectCopyTimeout(l, r, tt.timeouts)
+				ch <- copyResult{n1, n2, err}
+			}()
+			go io.Copy(io.Discard, rightPeer)
+
+			var res copyResult
+		loop:
+			for {
+				select {
+				case res = <-ch:
+					break loop
+				case <-time.After(20 * time.Millisecond):
+					if time.Since(start) < tt.active {
+						leftPeer.Write([]byte("ping"))
+					}
+				case <-timeout:
+					t.Fatalf("%s, watchdog=%v: copy did not return", tt.name, watchdog)
+				}
+			}
+
+			if !errors.Is(res.err, tt.want) {
+				t.Fatalf("%s, watchdog=%v: got error %v; want %v", tt.name, watchdog, res.err, tt.want)
+			}
+			if d := time.Since(start); d < tt.min {
+				t.Fatalf("%s, watchdog=%v: returned after %s", tt.name, watchdog, d)
+			}
+
+			// Both sides are closed.
+			if got := readAll(t, leftPeer); got != "" {
+				t.Fatalf("%s, watchdog=%v: got %q", tt.name, watchdog, got)
+			}
+		}
+	}
+}
+
+func TestStartHandshakeTimer(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	for _, mode := range []string{"deadline", "close"} {
+		conn := func() (io.ReadWriteCloser, net.Conn) {
+			peer, conn := tcpPair(t)
+			if mode == "close" {
+				return noDeadlineConn{conn}, peer
+			}
+			return conn, peer
+		}
+
+		// The handshake finishes in time; the connection stays
+		// usable afterwards.
+		rw, peer := conn()
+		stop := StartHandshakeTimer(rw, timeout)
+		if err := stop(); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		time.Sleep(2 * timeout)
+		go peer.Write([]byte("ok"))
+		if _, err := io.ReadFull(rw, make([]byte, 2)); err != nil {
+			t.Fatalf("%s: connection unusable: %s", mode, err)
+		}
+
+		// The handshake hangs.
+		rw, _ = conn()
+		stop = StartHandshakeTimer(rw, timeout)
+		if _, err := rw.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("%s: read did not fail", mode)
+		}
+		if err := stop(); !errors.Is(err, ErrHandshakeTimeout) {
+			t.Fatalf("%s: got error %v; want %v", mode, err, ErrHandshakeTimeout)
+		}
+	}
+
+	// A timeout of 0 disables the limit.
+	rw, _ := tcpPair(t)
+	if err := StartHandshakeTimer(rw, 0)(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The metrics of gcat. Connections are labeled with the proxy
+// scheme or the name of the service, e.g. "socks5".
+var (
+	SessionsActive = NewGauge("gcat_sessions_active",
+		"Number of active sessions.", "service")
+	ConnectionsAccepted = NewCounter("gcat_connections_accepted_total",
+		"Number of accepted connections.", "scheme")
+	ConnectionsDialed = NewCounter("gcat_connections_dialed_total",
+		"Number of dialed connections.", "scheme")
+	DialErrors = NewCounter("gcat_dial_errors_total",
+		"Number of failed dials by error type.", "scheme", "type")
+	Bytes = NewCounter("gcat_bytes_total",
+		"Number of transferred bytes per direction.", "service", "direction")
+	DoHQueries = NewCounter("gcat_doh_queries_total",
+		"Number of DoH queries per upstream and response code.", "upstream", "rcode")
+	DoHQueryDuration = NewHistogram("gcat_doh_query_duration_seconds",
+		"Latency of the upstream DNS queries.", nil, "upstream")
+	SOCKS5Requests = NewCounter("gcat_socks5_requests_total",
+		"Number of SOCKS5 requests by command and reply code.", "command", "reply")
+)
+
+// ErrorType classifies err for the type label of DialErrors.
+func ErrorType(err error) string {
+	var (
+		dnsErr  *net.DNSError
+		certErr *tls.CertificateVerificationError
+		recErr  tls.RecordHeaderError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &recErr):
+		return "tls"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "other"
+}
@@ -0,0 +1,234 @@
+// Package metrics implements counters, gauges and histograms with
+// labels and exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of latency histograms in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Histograms only; counts[i] is the number of observations
+	// <= buckets[i].
+	counts []uint64
+	count  uint64
+}
+
+// metric is a family of series which share a name and label names.
+type metric struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("BUG: %s: got %d label values; expected %d", m.name, len(labelValues), len(m.labelNames)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Registry holds metrics and writes them in the text format.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+// Default is the registry of the New* functions.
+var Default = &Registry{}
+
+func (r *Registry) register(m *metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m.series = make(map[string]*series)
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct{ m *metric }
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	m := &metric{name: name, help: help, typ: "counter", labelNames: labelNames}
+	Default.register(m)
+	return &Counter{m}
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.m.mutex.Lock()
+	defer c.m.mutex.Unlock()
+
+	c.m.get(labelValues).value += v
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value which goes up and down.
+type Gauge struct{ m *metric }
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	m := &metric{name: name, help: help, typ: "gauge", labelNames: labelNames}
+	Default.register(m)
+	return &Gauge{m}
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mutex.Lock()
+	defer g.m.mutex.Unlock()
+
+	g.m.get(labelValues).value += v
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mutex.Lock()
+	defer g.m.mutex.Unlock()
+
+	g.m.get(labelValues).value = v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram creates a histogram with the given ascending upper
+// bounds; nil means DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	m := &metric{name: name, help: help, typ: "histogram", labelNames: labelNames, buckets: buckets}
+	Default.register(m)
+	return &Histogram{m}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mutex.Lock()
+	defer h.m.mutex.Unlock()
+
+	s := h.m.get(labelValues)
+	for i, upper := range h.m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *metric) write(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Families without series are omitted.
+	if len(m.series) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.typ)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues), formatFloat(s.value))
+			continue
+		}
+		for i, upper := range m.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, formatLabels(m.labelNames, s.labelValues), formatFloat(s.value))
+		fmt.Fprintf(&b, "%s_count%s %d\n", m.name, formatLabels(m.labelNames, s.labelValues), s.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write writes all metrics of r in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	var (
+		c = NewCounter("test_requests_total", "Requests.", "path")
+		h = NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1})
+	)
+
+	c.Inc(`/a"b`)
+	c.Add(2, `/a"b`)
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	var b strings.Builder
+	if err := Default.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{path="/a\"b"} 3`,
+		`test_duration_seconds_bucket{le="0.1"} 1`,
+		`test_duration_seconds_bucket{le="1"} 2`,
+		`test_duration_seconds_bucket{le="+Inf"} 2`,
+		"test_duration_seconds_sum 0.55",
+		"test_duration_seconds_count 2",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+
+	// Families without series are omitted.
+	if strings.Contains(b.String(), "gcat_doh_queries_total") {
+		t.Error("empty family written")
+	}
+}
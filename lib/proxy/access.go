@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+)
+
+var (
+	// AccessOptions restrict the peers of listeners by their IP
+	// address; rejected connections are closed right after Accept().
+	AccessOptions = []ProxyOption[[]string]{
+		{
+			Name:        "allow",
+			Description: "accept only peers from these networks or addresses, e.g. 10.0.0.0/8",
+		},
+		{
+			Name:        "deny",
+			Description: "reject peers from these networks or addresses; takes precedence over allow",
+		},
+	}
+	// UIDOptions restrict the peers of unix socket listeners.
+	UIDOptions = []ProxyOption[[]string]{
+		{
+			Name:        "allow_uid",
+			Description: "accept only peers running as these users (names or IDs)",
+		},
+	}
+)
+
+// AccessPolicy parses the access options declared by p; it is nil
+// if none are set.
+func (p *ProxyDescription) AccessPolicy() (*helper.AccessPolicy, error) {
+	var (
+		policy helper.AccessPolicy
+		empty  = true
+	)
+
+	for _, opt := range p.ListOptions {
+		var parse func(string) error
+
+		switch opt.Name {
+		case "allow", "deny":
+			rules := &policy.Allow
+			if opt.Name == "deny" {
+				rules = &policy.Deny
+			}
+			parse = func(value string) error {
+				prefix, err := helper.ParsePrefix(value)
+				if err == nil {
+					*rules = append(*rules, prefix)
+				}
+				return err
+			}
+		case "allow_uid":
+			parse = func(value string) error {
+				uid, err := helper.ParseUID(value)
+				if err == nil {
+					policy.UIDs = append(policy.UIDs, uid)
+				}
+				return err
+			}
+		default:
+			continue
+		}
+
+		for _, value := range p.GetListOption(opt.Name) {
+			if err := parse(value); err != nil {
+				return nil, &OptionError{
+					Scheme: p.Scheme,
+					Key:    opt.Name,
+					Value:  value,
+					Err:    ErrInvalidOption,
+					Cause:  err,
+				}
+			}
+			empty = false
+		}
+	}
+
+	if empty {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
+// validateAccess rejects access options which are declared by a
+// stacked layer, but not by the base listener. Only the base listener
+// checks its peers; e.g. `tls-listen+unix-listen://…?allow=…` would
+// silently accept everyone.
+func (p *ProxyDescription) validateAccess() error {
+	base := p
+	for base.inner != nil {
+		base = base.inner
+	}
+	if base.listener == nil || base == p {
+		return nil
+	}
+
+	enforced := make(map[string]bool)
+	for _, opt := range base.ListOptions {
+		enforced[opt.Name] = true
+	}
+
+	query := p.Target().Query()
+	for layer := p; layer != base; layer = layer.inner {
+		for _, opt := range layer.ListOptions {
+			switch opt.Name {
+			case "allow", "deny", "allow_uid":
+			default:
+				continue
+			}
+			if !query.Has(opt.Name) || enforced[opt.Name] {
+				continue
+			}
+			return &OptionError{
+				Scheme: layer.Scheme,
+				Key:    opt.Name,
+				Value:  query.Get(opt.Name),
+				Err:    ErrInvalidOption,
+				Cause:  fmt.Errorf("not enforced on top of %s", base.Scheme),
+			}
+		}
+	}
+
+	return nil
+}
+
+// accept returns the next connection of the listener which passes
+// the access policy; the others are logged and closed.
+func (p *ProxyDescription) accept(ctx context.Context) (net.Conn, error) {
+	for {
+		conn, err := p.listener.Accept(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.access.Check(conn); err != nil {
+			var remote string
+			if addr := conn.RemoteAddr(); addr != nil {
+				remote = addr.String()
+			}
+			helper.LogRejected(remote, err, "proxy", p.Scheme)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
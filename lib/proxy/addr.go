@@ -3,6 +3,8 @@ package proxy
 import (
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ProxyAddr struct {
@@ -21,6 +23,14 @@ func (a *ProxyAddr) ProxyScheme() ProxyScheme {
 	return ProxyScheme(a.Scheme)
 }
 
+// WithScheme returns a copy of the address with its scheme
+// replaced; it is used to address the layers of a stacked proxy.
+func (a *ProxyAddr) WithScheme(scheme ProxyScheme) *ProxyAddr {
+	u := a.URL
+	u.Scheme = string(scheme)
+	return &ProxyAddr{u}
+}
+
 func (a *ProxyAddr) Network() string {
 	return string(a.ProxyScheme())
 }
@@ -66,12 +76,68 @@ func (a *ProxyAddr) GetIntOption(key string, base int, fallback int) (int, error
 	qs := a.URL.Query()
 
 	if qs.Has(key) {
-		v, err := strconv.ParseInt(qs.Get(key), 32, base)
+		v, err := strconv.ParseInt(qs.Get(key), base, 0)
 		return int(v), err
 	}
 	return fallback, nil
 }
 
+func (a *ProxyAddr) GetFloatOption(key string, fallback float64) (float64, error) {
+	qs := a.URL.Query()
+
+	if qs.Has(key) {
+		return strconv.ParseFloat(qs.Get(key), 64)
+	}
+	return fallback, nil
+}
+
+// GetDurationOption parses values such as `10s` or `1m30s`; plain
+// numbers are interpreted as seconds.
+func (a *ProxyAddr) GetDurationOption(key string, fallback time.Duration) (time.Duration, error) {
+	qs := a.URL.Query()
+
+	if qs.Has(key) {
+		return parseDuration(qs.Get(key))
+	}
+	return fallback, nil
+}
+
+func (a *ProxyAddr) GetSizeOption(key string, fallback Size) (Size, error) {
+	qs := a.URL.Query()
+
+	if qs.Has(key) {
+		return ParseSize(qs.Get(key))
+	}
+	return fallback, nil
+}
+
+// GetListOption collects the values of a repeated key; each value
+// might be a comma separated list as well, e.g. `a=1&a=2,3`.
+func (a *ProxyAddr) GetListOption(key string, fallback []string) []string {
+	qs := a.URL.Query()
+
+	if !qs.Has(key) {
+		return fallback
+	}
+
+	var out []string
+	for _, value := range qs[key] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
 func (a *ProxyAddr) String() string {
 	return a.URL.String()
 }
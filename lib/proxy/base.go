@@ -5,6 +5,12 @@ import (
 	"time"
 )
 
+// closeWriter is implemented by connections which support half-close;
+// wrappers pass it through to the connection they wrap.
+type closeWriter interface {
+	CloseWrite() error
+}
+
 type BaseConn struct {
 	LocalAddress  *ProxyAddr
 	RemoteAddress *ProxyAddr
@@ -22,11 +28,18 @@ func (c *BaseConn) Close() error {
 	return ErrNotImplemented
 }
 
+// Avoid returning a typed nil pointer as net.Addr.
 func (c *BaseConn) LocalAddr() net.Addr {
+	if c.LocalAddress == nil {
+		return nil
+	}
 	return c.LocalAddress
 }
 
 func (c *BaseConn) RemoteAddr() net.Addr {
+	if c.RemoteAddress == nil {
+		return nil
+	}
 	return c.RemoteAddress
 }
 
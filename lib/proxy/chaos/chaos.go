@@ -0,0 +1,333 @@
+// Package chaos implements a proxy layer which injects network
+// faults, e.g. to test how clients cope with bad links.
+package chaos
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+// ErrReset is returned by connections which were reset on purpose.
+var ErrReset = fmt.Errorf("injected reset: %w", syscall.ECONNRESET)
+
+// Faults describes the faults which are injected into a connection.
+// Rates are probabilities per chunk between 0 and 1.
+type Faults struct {
+	// Latency delays every chunk; Jitter varies the delay randomly
+	// by up to +/- Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Stall delays a chunk by Stall with the probability StallRate.
+	Stall     time.Duration
+	StallRate float64
+	// Fragment splits chunks into random pieces of at most this
+	// size if > 0; datagram connections are never fragmented.
+	Fragment int
+	// CorruptRate flips a random bit of a chunk.
+	CorruptRate float64
+	// ResetRate aborts the connection.
+	ResetRate float64
+	// DropRate and ReorderRate discard chunks or swap them with the
+	// following one; they are meant for datagram transports.
+	DropRate    float64
+	ReorderRate float64
+	// Read and Write select the affected directions: data read from
+	// or written to the underlying connection.
+	Read  bool
+	Write bool
+	// Seed makes the faults reproducible if != 0.
+	Seed int64
+}
+
+// faulter applies the faults to one direction of a connection.
+type faulter struct {
+	faults   *Faults
+	rand     *rand.Rand
+	fragment int
+
+	// A chunk held back in order to be reordered; it is flushed
+	// concurrently on Close().
+	mutex sync.Mutex
+	held  []byte
+}
+
+func newFaulter(faults *Faults, seed int64, datagrams bool) *faulter {
+	f := &faulter{faults: faults, rand: rand.New(rand.NewSource(seed))}
+	if !datagrams {
+		f.fragment = faults.Fragment
+	}
+	return f
+}
+
+// flush returns the chunk held back for reordering, if any.
+func (f *faulter) flush() []byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	held := f.held
+	f.held = nil
+	return held
+}
+
+func (f *faulter) chance(rate float64) bool {
+	return rate > 0 && f.rand.Float64() < rate
+}
+
+func (f *faulter) delay() time.Duration {
+	delay := f.faults.Latency
+	if j := f.faults.Jitter; j > 0 {
+		delay += time.Duration(f.rand.Int63n(int64(2*j))) - j
+	}
+	if f.chance(f.faults.StallRate) {
+		delay += f.faults.Stall
+	}
+	return max(delay, 0)
+}
+
+// process returns the chunks to pass on for p; nil means p was
+// dropped or held back.
+func (f *faulter) process(p []byte, done <-chan struct{}) ([][]byte, error) {
+	if f.chance(f.faults.ResetRate) {
+		return nil, ErrReset
+	}
+	if f.chance(f.faults.DropRate) {
+		return nil, nil
+	}
+
+	if delay := f.delay(); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return nil, net.ErrClosed
+		}
+	}
+
+	data := append([]byte(nil), p...)
+	if len(data) > 0 && f.chance(f.faults.CorruptRate) {
+		data[f.rand.Intn(len(data))] ^= 1 << f.rand.Intn(8)
+	}
+
+	chunks := [][]byte{data}
+	if held := f.flush(); held != nil {
+		chunks = append(chunks, held)
+	} else if f.chance(f.faults.ReorderRate) {
+		f.mutex.Lock()
+		f.held = data
+		f.mutex.Unlock()
+		return nil, nil
+	}
+
+	if f.fragment <= 0 {
+		return chunks, nil
+	}
+
+	var out [][]byte
+	for _, chunk := range chunks {
+		for len(chunk) > 0 {
+			n := min(1+f.rand.Intn(f.fragment), len(chunk))
+			out = append(out, chunk[:n])
+			chunk = chunk[n:]
+		}
+	}
+	return out, nil
+}
+
+// Wrap injects faults into conn.
+func Wrap(conn net.Conn, faults *Faults) net.Conn {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	var (
+		datagrams = proxy.GetConnInfo(conn).Datagrams
+		c         = &chaosConn{Conn: conn, done: make(chan struct{})}
+	)
+	if faults.Read {
+		c.read = newFaulter(faults, seed, datagrams)
+	}
+	if faults.Write {
+		c.write = newFaulter(faults, seed+1, datagrams)
+	}
+	return c
+}
+
+type chaosConn struct {
+	net.Conn
+	read  *faulter
+	write *faulter
+
+	buf     []byte
+	pending [][]byte
+	readErr error
+
+	once sync.Once
+	done chan struct{}
+}
+
+// abort resets the connection if the transport supports it and
+// closes it otherwise.
+func (c *chaosConn) abort(err error) error {
+	type linger interface {
+		SetLinger(sec int) error
+	}
+	if l, ok := c.Conn.(linger); ok {
+		l.SetLinger(0)
+	}
+	c.Close()
+	return err
+}
+
+func (c *chaosConn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.buf == nil {
+			c.buf = make([]byte, 32*1024)
+		}
+
+		n, err := c.Conn.Read(c.buf)
+		if n > 0 {
+			chunks, ferr := c.read.process(c.buf[:n], c.done)
+			if ferr != nil {
+				return 0, c.abort(ferr)
+			}
+			c.pending = chunks
+		}
+		// Do not lose a chunk held back for reordering.
+		if err != nil {
+			if held := c.read.flush(); held != nil {
+				c.pending = append(c.pending, held)
+			}
+		}
+		c.readErr = err
+	}
+
+	n := copy(p, c.pending[0])
+	if n < len(c.pending[0]) {
+		c.pending[0] = c.pending[0][n:]
+	} else {
+		c.pending = c.pending[1:]
+	}
+	return n, nil
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+
+	chunks, err := c.write.process(p, c.done)
+	if err != nil {
+		return 0, c.abort(err)
+	}
+
+	// The chunks of p come first; a chunk held back from a previous
+	// Write() might follow.
+	written := 0
+	for _, chunk := range chunks {
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return min(written, len(p)), err
+		}
+	}
+	return len(p), nil
+}
+
+// flushWrite writes the chunk held back for reordering, if any.
+func (c *chaosConn) flushWrite() {
+	if c.write == nil {
+		return
+	}
+	if held := c.write.flush(); held != nil {
+		c.Conn.Write(held)
+	}
+}
+
+func (c *chaosConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.flushWrite()
+	return c.Conn.Close()
+}
+
+func (c *chaosConn) CloseWrite() error {
+	c.flushWrite()
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+func (c *chaosConn) ConnInfo() proxy.ConnInfo {
+	return proxy.GetConnInfo(c.Conn)
+}
+
+// parseRate checks that the probability key, e.g. `0.05`, is between
+// 0 and 1. Percentages are not supported as "%" starts an escape
+// sequence in URLs.
+func parseRate(desc *proxy.ProxyDescription, key string) (float64, error) {
+	rate := desc.GetFloatOption(key)
+	if !(rate >= 0 && rate <= 1) {
+		return 0, &proxy.OptionError{
+			Scheme: desc.Scheme,
+			Key:    key,
+			Value:  strconv.FormatFloat(rate, 'g', -1, 64),
+			Err:    proxy.ErrInvalidOption,
+			Cause:  fmt.Errorf("must be between 0 and 1"),
+		}
+	}
+	return rate, nil
+}
+
+func ParseOptions(desc *proxy.ProxyDescription) (*Faults, error) {
+	faults := &Faults{
+		Latency:  desc.GetDurationOption("latency"),
+		Jitter:   desc.GetDurationOption("jitter"),
+		Stall:    desc.GetDurationOption("stall"),
+		Fragment: int(desc.GetSizeOption("fragment")),
+		Seed:     int64(desc.GetIntOption("seed")),
+	}
+
+	for _, opt := range []struct {
+		key  string
+		rate *float64
+	}{
+		{"stall_rate", &faults.StallRate},
+		{"corrupt_rate", &faults.CorruptRate},
+		{"reset_rate", &faults.ResetRate},
+		{"drop_rate", &faults.DropRate},
+		{"reorder_rate", &faults.ReorderRate},
+	} {
+		var err error
+		if *opt.rate, err = parseRate(desc, opt.key); err != nil {
+			return nil, err
+		}
+	}
+
+	switch desc.GetStringOption("direction") {
+	case "read":
+		faults.Read = true
+	case "write":
+		faults.Write = true
+	default:
+		faults.Read, faults.Write = true, true
+	}
+
+	return faults, nil
+}
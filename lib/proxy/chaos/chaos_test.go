@@ -0,0 +1,150 @@
+package chaos
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+func TestFragmentAndReorder(t *testing.T) {
+	f := newFaulter(&Faults{Fragment: 3, ReorderRate: 1}, 1, false)
+
+	chunks, err := f.process([]byte("first"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks != nil {
+		t.Fatalf("expected first chunk to be held back; got %q", chunks)
+	}
+
+	chunks, err = f.process([]byte("second"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []byte
+	for _, chunk := range chunks {
+		if len(chunk) > 3 {
+			t.Fatalf("chunk %q exceeds fragment size", chunk)
+		}
+		out = append(out, chunk...)
+	}
+	if string(out) != "secondfirst" {
+		t.Fatalf("got %q; expected %q", out, "secondfirst")
+	}
+}
+
+func TestConnRead(t *testing.T) {
+	client, server := net.Pipe()
+	conn := Wrap(client, &Faults{Fragment: 2, Read: true, Seed: 1})
+
+	data := []byte("hello chaos")
+	go func() {
+		server.Write(data)
+		server.Close()
+	}()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q; expected %q", got, data)
+	}
+}
+
+func TestReorderFlush(t *testing.T) {
+	// The only chunk is held back; EOF must release it.
+	client, server := net.Pipe()
+	conn := Wrap(client, &Faults{ReorderRate: 1, Read: true, Seed: 1})
+
+	go func() {
+		server.Write([]byte("held"))
+		server.Close()
+	}()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "held" {
+		t.Fatalf("read: got %q", got)
+	}
+
+	// The same applies to Close() on the write side.
+	client, server = net.Pipe()
+	conn = Wrap(client, &Faults{ReorderRate: 1, Write: true, Seed: 1})
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(server)
+		done <- data
+	}()
+
+	if n, err := conn.Write([]byte("held")); err != nil || n != 4 {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+	conn.Close()
+
+	if got := <-done; string(got) != "held" {
+		t.Fatalf("write: got %q", got)
+	}
+}
+
+type datagramConn struct {
+	net.Conn
+}
+
+func (c *datagramConn) ConnInfo() proxy.ConnInfo {
+	return proxy.ConnInfo{Datagrams: true}
+}
+
+func TestNoFragmentDatagrams(t *testing.T) {
+	client, server := net.Pipe()
+	conn := Wrap(&datagramConn{client}, &Faults{Fragment: 2, Read: true, Seed: 1})
+
+	go func() {
+		server.Write([]byte("packet"))
+		server.Close()
+	}()
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "packet" {
+		t.Fatalf("got %q", buf[:n])
+	}
+}
+
+// failingConn accepts limit bytes and fails afterwards.
+type failingConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	n := min(len(p), c.limit)
+	c.limit -= n
+	if n < len(p) {
+		return n, io.ErrClosedPipe
+	}
+	return n, nil
+}
+
+func TestConnWritePartial(t *testing.T) {
+	client, _ := net.Pipe()
+	conn := Wrap(&failingConn{Conn: client, limit: 5}, &Faults{Fragment: 2, Write: true, Seed: 1})
+
+	n, err := conn.Write([]byte("hello chaos"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if n != 5 {
+		t.Fatalf("got %d bytes written; expected 5", n)
+	}
+}
@@ -0,0 +1,155 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+var errNotStacked = errors.New("must be stacked on another module, e.g. chaos+tcp://")
+
+type dialer struct{}
+
+func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	return nil, errNotStacked
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	faults, err := ParseOptions(desc)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, faults), nil
+}
+
+type listener struct {
+	ln     net.Listener
+	faults *Faults
+}
+
+func (ln *listener) IsListening() bool {
+	return ln.ln != nil
+}
+
+func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	return errNotStacked
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	faults, err := ParseOptions(desc)
+	if err != nil {
+		return err
+	}
+
+	ln.ln = inner
+	ln.faults = faults
+
+	return nil
+}
+
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := proxy.AcceptContext(ctx, ln.ln)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, ln.faults), nil
+}
+
+func (ln *listener) Close() error {
+	return ln.ln.Close()
+}
+
+var (
+	StringOptions = []proxy.ProxyOption[string]{
+		{
+			Name:        "direction",
+			Description: "affected data: read from or written to the underlying connection",
+			Default:     "both",
+			Choices:     []string{"both", "read", "write"},
+		},
+	}
+	FloatOptions = []proxy.ProxyOption[float64]{
+		{
+			Name:        "stall_rate",
+			Description: "probability of a stall per chunk, e.g. 0.01",
+		},
+		{
+			Name:        "corrupt_rate",
+			Description: "probability of a flipped bit per chunk",
+		},
+		{
+			Name:        "reset_rate",
+			Description: "probability of a connection reset per chunk",
+		},
+		{
+			Name:        "drop_rate",
+			Description: "probability of a dropped chunk; for datagram transports",
+		},
+		{
+			Name:        "reorder_rate",
+			Description: "probability of a chunk being swapped with the next one; for datagram transports",
+		},
+	}
+	DurationOptions = []proxy.ProxyOption[time.Duration]{
+		{
+			Name:        "latency",
+			Description: "delay every chunk",
+		},
+		{
+			Name:        "jitter",
+			Description: "vary the latency randomly by up to +/- this duration",
+		},
+		{
+			Name:        "stall",
+			Description: "duration of stalls",
+			Default:     5 * time.Second,
+		},
+	}
+	SizeOptions = []proxy.ProxyOption[proxy.Size]{
+		{
+			Name:        "fragment",
+			Description: "split chunks into random pieces of at most this size",
+		},
+	}
+	IntOptions = []proxy.ProxyOption[int]{
+		{
+			Name:        "seed",
+			Description: "seed of the random faults for reproducible runs; 0 picks a random seed",
+		},
+	}
+)
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "chaos",
+		Description:      "inject network faults into the connection of the module below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy tcp-listen://:8080 'chaos+tcp://backend:80?latency=100ms&jitter=20ms&reset_rate=0.001'",
+			"# gcat proxy 'chaos+tun://10.0.0.1/24?drop_rate=0.05&reorder_rate=0.01' exec:'ssh root@HOST gcat proxy tun://10.0.0.2/24 -'",
+		},
+		StringOptions:   StringOptions,
+		FloatOptions:    FloatOptions,
+		DurationOptions: DurationOptions,
+		SizeOptions:     SizeOptions,
+		IntOptions:      IntOptions,
+		NewDialer:       func() proxy.ProxyDialer { return &dialer{} },
+	})
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "chaos-listen",
+		Description:      "inject network faults into the connections of the listener below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy 'chaos-listen+tcp-listen://:8080?fragment=16&stall_rate=0.01' tcp://backend:80",
+		},
+		StringOptions:   StringOptions,
+		FloatOptions:    FloatOptions,
+		DurationOptions: DurationOptions,
+		SizeOptions:     SizeOptions,
+		IntOptions:      IntOptions,
+		NewListener:     func() proxy.ProxyListener { return &listener{} },
+	})
+}
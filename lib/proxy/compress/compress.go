@@ -0,0 +1,432 @@
+// Package compress implements a proxy layer which compresses the
+// data of a stream transport. Both ends must use the layer; the
+// algorithm is negotiated when the connection is established.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+// Compression levels.
+const (
+	LevelFastest = "fastest"
+	LevelDefault = "default"
+	LevelBetter  = "better"
+	LevelBest    = "best"
+)
+
+var (
+	ErrNoAlgorithm = errors.New("no common compression algorithm")
+	errBadHello    = errors.New("peer does not speak the compression protocol")
+)
+
+// magic starts the handshake messages; the last byte is the
+// protocol version.
+var magic = []byte{'G', 'C', 'Z', 1}
+
+type writeFlusher interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type codec struct {
+	id        byte
+	name      string
+	newWriter func(w io.Writer, level string) (writeFlusher, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = []codec{
+	{
+		id:   1,
+		name: "zstd",
+		newWriter: func(w io.Writer, level string) (writeFlusher, error) {
+			l := map[string]zstd.EncoderLevel{
+				LevelFastest: zstd.SpeedFastest,
+				LevelDefault: zstd.SpeedDefault,
+				LevelBetter:  zstd.SpeedBetterCompression,
+				LevelBest:    zstd.SpeedBestCompression,
+			}[level]
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(l), zstd.WithEncoderConcurrency(1))
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		id:   2,
+		name: "deflate",
+		newWriter: func(w io.Writer, level string) (writeFlusher, error) {
+			l := map[string]int{
+				LevelFastest: flate.BestSpeed,
+				LevelDefault: flate.DefaultCompression,
+				LevelBetter:  7,
+				LevelBest:    flate.BestCompression,
+			}[level]
+			return flate.NewWriter(w, l)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	},
+}
+
+// Algorithms are the names of the supported algorithms in the
+// default order of preference.
+func Algorithms() []string {
+	var out []string
+	for _, c := range codecs {
+		out = append(out, c.name)
+	}
+	return out
+}
+
+func codecByName(name string) *codec {
+	for i := range codecs {
+		if codecs[i].name == name {
+			return &codecs[i]
+		}
+	}
+	return nil
+}
+
+func codecByID(id byte) *codec {
+	for i := range codecs {
+		if codecs[i].id == id {
+			return &codecs[i]
+		}
+	}
+	return nil
+}
+
+// Options configure the compression layer.
+type Options struct {
+	// Algorithms in order of preference; the listener picks its
+	// most preferred algorithm which the dialer offers.
+	Algorithms []string
+	Level      string
+	// FlushDelay is the maximum time compressed data is held back
+	// in order to be compressed together with subsequent writes;
+	// 0 flushes after every write.
+	FlushDelay time.Duration
+}
+
+func (o *Options) ids() []byte {
+	var ids []byte
+	for _, name := range o.Algorithms {
+		if c := codecByName(name); c != nil {
+			ids = append(ids, c.id)
+		}
+	}
+	return ids
+}
+
+// clientHandshake offers the algorithms of opts and returns the
+// choice of the peer.
+func clientHandshake(conn net.Conn, opts *Options) (*codec, error) {
+	ids := opts.ids()
+	hello := append(append(append([]byte(nil), magic...), byte(len(ids))), ids...)
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(reply[:len(magic)], magic) {
+		return nil, errBadHello
+	}
+	if reply[len(magic)] == 0 {
+		return nil, ErrNoAlgorithm
+	}
+
+	c := codecByID(reply[len(magic)])
+	if c == nil || bytes.IndexByte(ids, c.id) < 0 {
+		return nil, fmt.Errorf("peer chose unknown algorithm %d", reply[len(magic)])
+	}
+	return c, nil
+}
+
+// serverHandshake picks an algorithm of the dialer's offer.
+func serverHandshake(conn net.Conn, opts *Options) (*codec, error) {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, errBadHello
+	}
+
+	offer := make([]byte, header[len(magic)])
+	if _, err := io.ReadFull(conn, offer); err != nil {
+		return nil, err
+	}
+
+	var choice *codec
+	for _, id := range opts.ids() {
+		if bytes.IndexByte(offer, id) >= 0 {
+			choice = codecByID(id)
+			break
+		}
+	}
+
+	reply := append([]byte(nil), magic...)
+	if choice == nil {
+		conn.Write(append(reply, 0))
+		return nil, ErrNoAlgorithm
+	}
+	if _, err := conn.Write(append(reply, choice.id)); err != nil {
+		return nil, err
+	}
+	return choice, nil
+}
+
+// Dial runs the handshake of the dialing side on conn.
+func Dial(ctx context.Context, conn net.Conn, opts *Options) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	codec, err := clientHandshake(conn, opts)
+
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(conn, opts)
+	c.once.Do(func() { c.hsErr = c.init(codec) })
+	if c.hsErr != nil {
+		return nil, c.hsErr
+	}
+	return c, nil
+}
+
+// Server wraps conn, which was accepted by a listener. The handshake
+// runs on the first Read() or Write() such that slow clients do not
+// block the listener.
+func Server(conn net.Conn, opts *Options) net.Conn {
+	return newConn(conn, opts)
+}
+
+func newConn(conn net.Conn, opts *Options) *compressConn {
+	return &compressConn{Conn: conn, opts: opts, stats: &proxy.CompressionStats{}}
+}
+
+// countReader counts the wire bytes of the decompressor and
+// remembers whether the transport hit EOF.
+type countReader struct {
+	r   io.Reader
+	n   *atomic.Int64
+	eof bool
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+type compressConn struct {
+	net.Conn
+	opts  *Options
+	stats *proxy.CompressionStats
+
+	once  sync.Once
+	hsErr error
+
+	cr *countReader
+	r  io.ReadCloser
+
+	mutex    sync.Mutex
+	w        writeFlusher
+	timer    *time.Timer
+	pending  bool
+	writeErr error
+	closed   bool
+}
+
+func (c *compressConn) init(codec *codec) error {
+	var err error
+
+	c.cr = &countReader{r: c.Conn, n: &c.stats.WireIn}
+	if c.r, err = codec.newReader(c.cr); err != nil {
+		return err
+	}
+	if c.w, err = codec.newWriter(&countWriter{w: c.Conn, n: &c.stats.WireOut}, c.opts.Level); err != nil {
+		return err
+	}
+
+	c.stats.SetAlgorithm(codec.name)
+	return nil
+}
+
+func (c *compressConn) handshake() error {
+	c.once.Do(func() {
+		codec, err := serverHandshake(c.Conn, c.opts)
+		if err != nil {
+			c.hsErr = err
+			return
+		}
+		c.hsErr = c.init(codec)
+	})
+	return c.hsErr
+}
+
+func (c *compressConn) Read(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	c.stats.RawIn.Add(int64(n))
+
+	// The peer closed the transport without finishing the stream,
+	// e.g. because it does not support half-close.
+	if errors.Is(err, io.ErrUnexpectedEOF) && c.cr.eof {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := c.w.Write(p)
+	c.stats.RawOut.Add(int64(n))
+	if err != nil {
+		c.writeErr = err
+		return n, err
+	}
+
+	switch {
+	case c.opts.FlushDelay <= 0:
+		if err := c.w.Flush(); err != nil {
+			c.writeErr = err
+			return n, err
+		}
+	case c.pending:
+		// The running timer flushes this write as well.
+	case c.timer == nil:
+		c.timer = time.AfterFunc(c.opts.FlushDelay, c.flush)
+		c.pending = true
+	default:
+		c.timer.Reset(c.opts.FlushDelay)
+		c.pending = true
+	}
+
+	return n, nil
+}
+
+// flush writes the compressed data which was held back.
+func (c *compressConn) flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pending = false
+	if c.closed || c.writeErr != nil {
+		return
+	}
+	c.writeErr = c.w.Flush()
+}
+
+// finish ends the compressed stream; it must be called with the
+// mutex held.
+func (c *compressConn) finish() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if c.w == nil || c.writeErr != nil {
+		return c.writeErr
+	}
+	return c.w.Close()
+}
+
+func (c *compressConn) CloseWrite() error {
+	c.mutex.Lock()
+	err := c.finish()
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+// Close aborts the compressed stream; use CloseWrite() in order to
+// finish it.
+func (c *compressConn) Close() error {
+	// Unblock a pending Write() first, which holds the mutex.
+	err := c.Conn.Close()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.closed = true
+
+	return err
+}
+
+func (c *compressConn) ConnInfo() proxy.ConnInfo {
+	info := proxy.GetConnInfo(c.Conn)
+	info.Compression = c.stats
+	info.Datagrams = false
+	return info
+}
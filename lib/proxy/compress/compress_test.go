@@ -0,0 +1,59 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, algo := range Algorithms() {
+		client, server := net.Pipe()
+		srv := Server(server, &Options{Algorithms: Algorithms(), Level: LevelDefault})
+
+		data := bytes.Repeat([]byte("gcat compresses well. "), 1000)
+		received := make(chan []byte, 1)
+		go func() {
+			b, _ := io.ReadAll(srv)
+			received <- b
+		}()
+
+		conn, err := Dial(context.Background(), client, &Options{Algorithms: []string{algo}, Level: LevelDefault})
+		if err != nil {
+			t.Fatalf("%s: %s", algo, err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("%s: %s", algo, err)
+		}
+		conn.(*compressConn).CloseWrite()
+		conn.Close()
+
+		if got := <-received; !bytes.Equal(got, data) {
+			t.Fatalf("%s: got %d bytes; expected %d", algo, len(got), len(data))
+		}
+
+		stats := proxy.GetConnInfo(conn).Compression
+		if stats.Algorithm() != algo {
+			t.Fatalf("got algorithm %s; expected %s", stats.Algorithm(), algo)
+		}
+		if stats.Ratio() <= 1 {
+			t.Fatalf("%s: unexpected ratio %f", algo, stats.Ratio())
+		}
+	}
+}
+
+func TestNoCommonAlgorithm(t *testing.T) {
+	client, server := net.Pipe()
+	srv := Server(server, &Options{Algorithms: []string{"deflate"}})
+	go srv.Read(make([]byte, 1))
+
+	_, err := Dial(context.Background(), client, &Options{Algorithms: []string{"zstd"}})
+	if !errors.Is(err, ErrNoAlgorithm) {
+		t.Fatalf("got %v; expected %v", err, ErrNoAlgorithm)
+	}
+}
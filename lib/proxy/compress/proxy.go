@@ -0,0 +1,115 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+var errNotStacked = errors.New("must be stacked on another module, e.g. compress+tcp://")
+
+func ParseOptions(desc *proxy.ProxyDescription) *Options {
+	return &Options{
+		Algorithms: desc.GetListOption("algo"),
+		Level:      desc.GetStringOption("level"),
+		FlushDelay: desc.GetDurationOption("flush_delay"),
+	}
+}
+
+type dialer struct{}
+
+func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	return nil, errNotStacked
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	return Dial(ctx, conn, ParseOptions(desc))
+}
+
+type listener struct {
+	ln   net.Listener
+	opts *Options
+}
+
+func (ln *listener) IsListening() bool {
+	return ln.ln != nil
+}
+
+func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	return errNotStacked
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	ln.ln = inner
+	ln.opts = ParseOptions(desc)
+	return nil
+}
+
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := proxy.AcceptContext(ctx, ln.ln)
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, ln.opts), nil
+}
+
+func (ln *listener) Close() error {
+	return ln.ln.Close()
+}
+
+var (
+	StringOptions = []proxy.ProxyOption[string]{
+		{
+			Name:        "level",
+			Description: "compression level",
+			Default:     LevelDefault,
+			Choices:     []string{LevelFastest, LevelDefault, LevelBetter, LevelBest},
+		},
+	}
+	ListOptions = []proxy.ProxyOption[[]string]{
+		{
+			Name:        "algo",
+			Description: "compression algorithms in order of preference",
+			Default:     Algorithms(),
+			Choices:     Algorithms(),
+		},
+	}
+	DurationOptions = []proxy.ProxyOption[time.Duration]{
+		{
+			Name:        "flush_delay",
+			Description: "hold back compressed data for at most this long in order to compress it with subsequent writes; 0 flushes every write",
+			Default:     2 * time.Millisecond,
+		},
+	}
+)
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "compress",
+		Description:      "compress the connection of the module below; the peer must use compress-listen",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy tcp-listen://localhost:8080 'compress+quic://example.org:4433?algo=zstd'",
+			"# gcat proxy tun://192.168.255.1/24 compress+exec:'ssh root@HOST gcat proxy compress-listen+stdio: tun://192.168.255.2/24'",
+		},
+		StringOptions:   StringOptions,
+		ListOptions:     ListOptions,
+		DurationOptions: DurationOptions,
+		NewDialer:       func() proxy.ProxyDialer { return &dialer{} },
+	})
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "compress-listen",
+		Description:      "compress the connections of the listener below; the peers must use compress",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy compress-listen+quic-listen://:4433 tcp://localhost:22",
+		},
+		StringOptions:   StringOptions,
+		ListOptions:     ListOptions,
+		DurationOptions: DurationOptions,
+		NewListener:     func() proxy.ProxyListener { return &listener{} },
+	})
+}
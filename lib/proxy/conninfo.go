@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo is the metadata record of a proxy connection. Fields
+// which do not apply to a connection are left empty.
+type ConnInfo struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	TLS        *tls.ConnectionState
+	ALPN       string
+	// Header contains the HTTP headers of the websocket handshake;
+	// the request headers on the server side and the response
+	// headers on the client side.
+	Header     http.Header
+	QUICConnID string
+	// Compression is set for connections of compression layers; the
+	// counters are updated while the connection is in use.
+	Compression *CompressionStats
+	// Datagrams is set for connections which preserve message
+	// boundaries: every Write() sends one message and every Read()
+	// returns one message.
+	Datagrams bool
+}
+
+// CompressionStats counts the bytes of a compressed connection
+// before (raw) and after (wire) compression.
+type CompressionStats struct {
+	algorithm atomic.Pointer[string]
+
+	RawIn   atomic.Int64
+	WireIn  atomic.Int64
+	RawOut  atomic.Int64
+	WireOut atomic.Int64
+}
+
+func (s *CompressionStats) SetAlgorithm(name string) {
+	s.algorithm.Store(&name)
+}
+
+// Algorithm is empty until the algorithm is negotiated.
+func (s *CompressionStats) Algorithm() string {
+	if name := s.algorithm.Load(); name != nil {
+		return *name
+	}
+	return ""
+}
+
+// Ratio is the raw size divided by the wire size of both
+// directions; 0 if nothing was transferred.
+func (s *CompressionStats) Ratio() float64 {
+	wire := s.WireIn.Load() + s.WireOut.Load()
+	if wire == 0 {
+		return 0
+	}
+	return float64(s.RawIn.Load()+s.RawOut.Load()) / float64(wire)
+}
+
+func (i ConnInfo) LogValue() slog.Value {
+	var attrs []slog.Attr
+
+	if i.LocalAddr != nil {
+		attrs = append(attrs, slog.String("local", i.LocalAddr.String()))
+	}
+	if i.RemoteAddr != nil {
+		attrs = append(attrs, slog.String("remote", i.RemoteAddr.String()))
+	}
+	if i.TLS != nil && i.TLS.HandshakeComplete {
+		attrs = append(attrs, slog.String("tls_version", tls.VersionName(i.TLS.Version)))
+		attrs = append(attrs, slog.String("tls_cipher", tls.CipherSuiteName(i.TLS.CipherSuite)))
+	}
+	if i.ALPN != "" {
+		attrs = append(attrs, slog.String("alpn", i.ALPN))
+	}
+	if ua := i.Header.Get("User-Agent"); ua != "" {
+		attrs = append(attrs, slog.String("user_agent", ua))
+	}
+	if i.QUICConnID != "" {
+		attrs = append(attrs, slog.String("quic_conn_id", i.QUICConnID))
+	}
+	if i.Compression != nil && i.Compression.Algorithm() != "" {
+		attrs = append(attrs, slog.String("compression", i.Compression.Algorithm()))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// ConnInfoProvider is implemented by connections which know more
+// about themselves than the net.Conn interface exposes.
+type ConnInfoProvider interface {
+	ConnInfo() ConnInfo
+}
+
+// GetConnInfo collects the metadata of conn. For TLS connections
+// the metadata of the underlying connection is included, such that
+// e.g. `tls+ws` connections expose both TLS state and HTTP headers.
+func GetConnInfo(conn net.Conn) ConnInfo {
+	if p, ok := conn.(ConnInfoProvider); ok {
+		return p.ConnInfo()
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		var (
+			info  = GetConnInfo(tlsConn.NetConn())
+			state = tlsConn.ConnectionState()
+		)
+
+		info.LocalAddr = tlsConn.LocalAddr()
+		info.RemoteAddr = tlsConn.RemoteAddr()
+		info.TLS = &state
+		info.ALPN = state.NegotiatedProtocol
+
+		return info
+	}
+
+	info := ConnInfo{
+		LocalAddr:  conn.LocalAddr(),
+		RemoteAddr: conn.RemoteAddr(),
+	}
+
+	switch conn.(type) {
+	case *net.UDPConn:
+		info.Datagrams = true
+	case *net.UnixConn:
+		for _, addr := range []net.Addr{info.LocalAddr, info.RemoteAddr} {
+			if addr, ok := addr.(*net.UnixAddr); ok && addr != nil {
+				info.Datagrams = addr.Net == "unixgram" || addr.Net == "unixpacket"
+				break
+			}
+		}
+	}
+
+	return info
+}
+
+// aLongTimeAgo is used to unblock a pending Accept().
+var aLongTimeAgo = time.Unix(1, 0)
+
+// AcceptContext is net.Listener.Accept() with support for cancellation
+// and deadlines via ctx. Listeners with SetDeadline(), such as TCP or
+// unix listeners, are interrupted directly; all others are raced
+// against ctx in a goroutine. Listeners providing their own
+// AcceptContext() method are called with ctx as is.
+func AcceptContext(ctx context.Context, ln net.Listener) (net.Conn, error) {
+	type deadliner interface {
+		SetDeadline(t time.Time) error
+	}
+	type contextAcceptor interface {
+		AcceptContext(ctx context.Context) (net.Conn, error)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if a, ok := ln.(contextAcceptor); ok {
+		return a.AcceptContext(ctx)
+	}
+
+	if d, ok := ln.(deadliner); ok {
+		deadline, _ := ctx.Deadline()
+		if err := d.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			d.SetDeadline(aLongTimeAgo)
+			close(interrupted)
+		})
+
+		conn, err := ln.Accept()
+
+		// Leave the listener in a usable state for the next call.
+		if !stop() {
+			<-interrupted
+		}
+		d.SetDeadline(time.Time{})
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// The timer of ctx might fire slightly after the socket deadline.
+			if !deadline.IsZero() && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, context.DeadlineExceeded
+			}
+		}
+		return conn, err
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// Do not leak a connection which arrives too late.
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
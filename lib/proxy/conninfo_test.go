@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// plainListener hides SetDeadline() of the wrapped listener.
+type plainListener struct {
+	net.Listener
+}
+
+func TestAcceptContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := AcceptContext(ctx, ln); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v; expected %v", err, context.DeadlineExceeded)
+	}
+
+	// The listener must still be usable after a cancelled Accept().
+	go func() {
+		if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := AcceptContext(context.Background(), ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestAcceptContextFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := AcceptContext(ctx, &plainListener{ln}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v; expected %v", err, context.Canceled)
+	}
+}
+
+func TestConnInfoDatagrams(t *testing.T) {
+	path := t.TempDir() + "/gcat.sock"
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !GetConnInfo(conn).Datagrams {
+		t.Fatal("unixgram connection is not marked as datagrams")
+	}
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	if GetConnInfo(c).Datagrams {
+		t.Fatal("pipe is marked as datagrams")
+	}
+}
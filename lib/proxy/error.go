@@ -1,10 +1,52 @@
 package proxy
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+)
 
 var (
-	ErrNotSupported        = errors.New("method not supported")
+	ErrNotSupported        = helper.ErrNotSupported
 	ErrProxyBusy           = errors.New("proxy is busy")
 	ErrProxyNotInitialized = errors.New("proxy is not initialized")
 	ErrNotImplemented      = errors.New("proxy method not implemented")
+	ErrNoSuchProxy         = errors.New("no such proxy")
+	ErrUnknownOption       = errors.New("unknown option")
+	ErrInvalidOption       = errors.New("invalid option value")
 )
+
+// OptionError describes a query parameter which was rejected
+// while validating a proxy address.
+type OptionError struct {
+	Scheme     ProxyScheme
+	Key        string
+	Value      string
+	Suggestion string
+	Err        error // ErrUnknownOption or ErrInvalidOption
+	Cause      error
+}
+
+func (e *OptionError) Error() string {
+	if errors.Is(e.Err, ErrUnknownOption) {
+		msg := fmt.Sprintf("%s: unknown option %q", e.Scheme, e.Key)
+		if e.Suggestion != "" {
+			msg += fmt.Sprintf("; did you mean %q?", e.Suggestion)
+		}
+		return msg
+	}
+
+	msg := fmt.Sprintf("%s: invalid value %q for option %q", e.Scheme, e.Value, e.Key)
+	if e.Cause != nil {
+		msg += fmt.Sprintf(": %s", e.Cause)
+	}
+	return msg
+}
+
+func (e *OptionError) Unwrap() []error {
+	if e.Cause != nil {
+		return []error{e.Err, e.Cause}
+	}
+	return []error{e.Err}
+}
@@ -27,6 +27,12 @@ func (w *cmdConn) Read(p []byte) (int, error) {
 	return w.stdout.Read(p)
 }
 
+// CloseWrite closes stdin; the command sees EOF while its output
+// can still be read.
+func (w *cmdConn) CloseWrite() error {
+	return w.stdin.Close()
+}
+
 func (w *cmdConn) Close() error {
 	if w.command.Process != nil {
 		if err := w.command.Process.Kill(); err != nil {
@@ -93,7 +99,7 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "exec",
 		Description: "spawn a programm and connect via stdio",
-		Dialer:      &execDialer{},
+		NewDialer:   func() proxy.ProxyDialer { return &execDialer{} },
 		Examples: []string{
 			"$ gcat proxy 'exec:?cmd=cat -'",
 			"$ gcat proxy 'exec:cat -'",
@@ -109,7 +115,7 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "system",
 		Description: "execute `cmd` via a shell and connect via stdio",
-		Dialer:      &shellDialer{},
+		NewDialer:   func() proxy.ProxyDialer { return &shellDialer{} },
 		Examples: []string{
 			"$ gcat proxy 'system:?cmd=cat -'",
 			"$ gcat proxy 'system:cat -'",
@@ -0,0 +1,114 @@
+// Package frame carries datagrams over stream connections. Every
+// datagram is prefixed with its length as 32 bit big endian integer.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+const headerSize = 4
+
+// DefaultMaxSize is the largest datagram which is passed on intact
+// by a session.
+const DefaultMaxSize = helper.MaxDatagramSize
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// Wrap frames conn: every Write() is sent as one frame and every
+// Read() returns the payload of one frame. If p is too small, the
+// remainder of the frame is returned by the next Read(). Frames
+// larger than maxSize are rejected in both directions.
+func Wrap(conn net.Conn, maxSize int) net.Conn {
+	return &frameConn{Conn: conn, maxSize: maxSize}
+}
+
+type frameConn struct {
+	net.Conn
+	maxSize int
+
+	readMutex sync.Mutex
+	header    [headerSize]byte
+	pending   []byte
+
+	writeMutex sync.Mutex
+	buf        []byte
+}
+
+func (c *frameConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	// A clean EOF is only possible between frames.
+	if _, err := io.ReadFull(c.Conn, c.header[:]); err != nil {
+		return 0, err
+	}
+
+	size := int(binary.BigEndian.Uint32(c.header[:]))
+	if size > c.maxSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+
+	payload := p
+	if len(p) < size {
+		payload = make([]byte, size)
+	}
+	if _, err := io.ReadFull(c.Conn, payload[:size]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	if len(p) < size {
+		n := copy(p, payload)
+		c.pending = payload[n:size]
+		return n, nil
+	}
+	return size, nil
+}
+
+func (c *frameConn) Write(p []byte) (int, error) {
+	if len(p) > c.maxSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(p))
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	// Header and payload in one write, such that packet based
+	// transports below do not see a frame in two parts.
+	c.buf = binary.BigEndian.AppendUint32(c.buf[:0], uint32(len(p)))
+	c.buf = append(c.buf, p...)
+
+	if _, err := c.Conn.Write(c.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *frameConn) CloseWrite() error {
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+func (c *frameConn) ConnInfo() proxy.ConnInfo {
+	info := proxy.GetConnInfo(c.Conn)
+	info.Datagrams = true
+	return info
+}
@@ -0,0 +1,81 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	var (
+		client   = Wrap(c, DefaultMaxSize)
+		server   = Wrap(s, DefaultMaxSize)
+		messages = [][]byte{[]byte("hello"), bytes.Repeat([]byte("x"), 1500), []byte("bye")}
+	)
+
+	go func() {
+		for _, msg := range messages {
+			client.Write(msg)
+		}
+		c.Close()
+	}()
+
+	buf := make([]byte, DefaultMaxSize)
+	for _, msg := range messages {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("got %d bytes; expected %d", n, len(msg))
+		}
+	}
+	if _, err := server.Read(buf); err != io.EOF {
+		t.Fatalf("got %v; expected %v", err, io.EOF)
+	}
+}
+
+func TestShortBuffer(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go Wrap(c, DefaultMaxSize).Write([]byte("hello"))
+
+	var (
+		server = Wrap(s, DefaultMaxSize)
+		buf    = make([]byte, 3)
+		got    []byte
+	)
+	for len(got) < 5 {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q; expected %q", got, "hello")
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go Wrap(c, 16).Write(make([]byte, 10))
+
+	if _, err := Wrap(s, 8).Read(make([]byte, 16)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got %v; expected %v", err, ErrFrameTooLarge)
+	}
+	if _, err := Wrap(c, 8).Write(make([]byte, 10)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got %v; expected %v", err, ErrFrameTooLarge)
+	}
+}
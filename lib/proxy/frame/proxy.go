@@ -0,0 +1,84 @@
+package frame
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+var errNotStacked = errors.New("must be stacked on another module, e.g. frame+tcp://")
+
+type dialer struct{}
+
+func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	return nil, errNotStacked
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	return Wrap(conn, int(desc.GetSizeOption("max_size"))), nil
+}
+
+type listener struct {
+	ln      net.Listener
+	maxSize int
+}
+
+func (ln *listener) IsListening() bool {
+	return ln.ln != nil
+}
+
+func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	return errNotStacked
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	ln.ln = inner
+	ln.maxSize = int(desc.GetSizeOption("max_size"))
+	return nil
+}
+
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := proxy.AcceptContext(ctx, ln.ln)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, ln.maxSize), nil
+}
+
+func (ln *listener) Close() error {
+	return ln.ln.Close()
+}
+
+var SizeOptions = []proxy.ProxyOption[proxy.Size]{
+	{
+		Name:        "max_size",
+		Description: "largest accepted frame; larger frames end the connection",
+		Default:     DefaultMaxSize,
+	},
+}
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "frame",
+		Description:      "carry datagrams as length-prefixed frames over the module below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy unixgram:///run/app.sock frame+tcp://example.org:1234",
+			"$ gcat proxy 'frame+tls://example.org:1234' 'quic://localhost:4433?enable_datagrams=true'",
+		},
+		SizeOptions: SizeOptions,
+		NewDialer:   func() proxy.ProxyDialer { return &dialer{} },
+	})
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "frame-listen",
+		Description:      "carry datagrams as length-prefixed frames over the connections of the listener below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy frame-listen+tcp-listen://:1234 tun://10.0.0.1/24",
+		},
+		SizeOptions: SizeOptions,
+		NewListener: func() proxy.ProxyListener { return &listener{} },
+	})
+}
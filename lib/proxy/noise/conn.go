@@ -0,0 +1,191 @@
+package noise
+
+import (
+	"context"
+	"crypto/ecdh"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// maxPlaintext is the payload of a transport message.
+const maxPlaintext = maxMessage - chacha20poly1305.Overhead
+
+// Client runs the handshake as initiator on conn.
+func Client(ctx context.Context, conn net.Conn, config *Config) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	c := &noiseConn{Conn: conn, config: config}
+	c.once.Do(func() {
+		c.send, c.recv, c.peer, c.hsErr = handshake(conn, config, true)
+	})
+
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if c.hsErr != nil {
+		return nil, c.hsErr
+	}
+	return c, nil
+}
+
+// Server wraps conn, which was accepted by a listener. The handshake
+// runs on the first Read() or Write() such that slow clients do not
+// block the listener.
+func Server(conn net.Conn, config *Config) net.Conn {
+	return &noiseConn{Conn: conn, config: config}
+}
+
+type noiseConn struct {
+	net.Conn
+	config *Config
+
+	once  sync.Once
+	hsErr error
+	peer  *ecdh.PublicKey
+
+	readMutex sync.Mutex
+	recv      *cipherState
+	pending   []byte
+	readErr   error
+
+	writeMutex sync.Mutex
+	send       *cipherState
+	finished   bool
+}
+
+func (c *noiseConn) handshake() error {
+	c.once.Do(func() {
+		c.send, c.recv, c.peer, c.hsErr = handshake(c.Conn, c.config, false)
+	})
+	return c.hsErr
+}
+
+// HandshakeContext runs the handshake of a server connection before
+// the first Read() or Write(); ctx bounds it, e.g. with the
+// handshake timeout of the listener.
+func (c *noiseConn) HandshakeContext(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		c.Conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	err := c.handshake()
+
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+// PeerKey returns the static key of the peer; nil before the
+// handshake has completed.
+func (c *noiseConn) PeerKey() *ecdh.PublicKey {
+	if c.handshake() != nil {
+		return nil
+	}
+	return c.peer
+}
+
+func (c *noiseConn) Read(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		msg, err := readMessage(c.Conn)
+		if err == io.EOF {
+			// The stream was cut without the final empty
+			// message; it might have been truncated.
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			c.readErr = err
+			continue
+		}
+
+		plaintext, err := c.recv.decrypt(nil, msg)
+		if err != nil {
+			c.readErr = err
+			continue
+		}
+		if len(plaintext) == 0 {
+			c.readErr = io.EOF
+			continue
+		}
+		c.pending = plaintext
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *noiseConn) Write(p []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.finished {
+		return 0, net.ErrClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxPlaintext)
+		if err := writeMessage(c.Conn, c.send.encrypt(nil, p[:n])); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// finish sends the empty message which marks the end of the stream.
+func (c *noiseConn) finish() error {
+	if err := c.handshake(); err != nil {
+		return err
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.finished {
+		return nil
+	}
+	c.finished = true
+	return writeMessage(c.Conn, c.send.encrypt(nil, nil))
+}
+
+func (c *noiseConn) CloseWrite() error {
+	if err := c.finish(); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+func (c *noiseConn) ConnInfo() proxy.ConnInfo {
+	info := proxy.GetConnInfo(c.Conn)
+	info.Datagrams = false
+	return info
+}
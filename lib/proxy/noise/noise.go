@@ -0,0 +1,440 @@
+// Package noise implements a proxy layer which encrypts and
+// authenticates a stream with the Noise_XX handshake
+// (Noise_XX_25519_ChaChaPoly_SHA256); with a pre-shared key the
+// Noise_XXpsk3 variant is used. Static keys are X25519 keys; peers
+// are authenticated by the SHA256 fingerprints of their public keys.
+package noise
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// maxMessage is the maximum size of a Noise message.
+const maxMessage = 65535
+
+var (
+	ErrPeerNotTrusted = errors.New("peer is not trusted")
+	errDecrypt        = errors.New("message authentication failed")
+)
+
+// Fingerprint returns the SHA256 fingerprint of a public key.
+func Fingerprint(pub *ecdh.PublicKey) string {
+	digest := sha256.Sum256(pub.Bytes())
+	return hex.EncodeToString(digest[:])
+}
+
+// GenerateKey creates a static X25519 key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// LoadKey reads a PEM encoded PKCS#8 X25519 private key, e.g. as
+// created by `openssl genpkey -algorithm X25519`.
+func LoadKey(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s: not an X25519 key", path)
+	}
+	return priv, nil
+}
+
+// LoadPSK reads a pre-shared key file; keys of arbitrary length are
+// hashed to 32 bytes. Surrounding whitespace is ignored.
+func LoadPSK(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s: empty pre-shared key", path)
+	}
+	psk := sha256.Sum256(data)
+	return psk[:], nil
+}
+
+// Config configures one side of the handshake.
+type Config struct {
+	// Static is the long term key of this side.
+	Static *ecdh.PrivateKey
+	// PSK switches to Noise_XXpsk3 if set; it must be 32 bytes.
+	PSK []byte
+	// Verify is called with the static key of the peer; a non nil
+	// error aborts the handshake.
+	Verify func(pub *ecdh.PublicKey) error
+}
+
+func (c *Config) protocolName() string {
+	if c.PSK != nil {
+		return "Noise_XXpsk3_25519_ChaChaPoly_SHA256"
+	}
+	return "Noise_XX_25519_ChaChaPoly_SHA256"
+}
+
+// prologue binds the handshake to this application.
+var prologue = []byte("gcat")
+
+type cipherState struct {
+	key   []byte
+	nonce uint64
+}
+
+func (cs *cipherState) nonceBytes() []byte {
+	var n [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(n[4:], cs.nonce)
+	return n[:]
+}
+
+func (cs *cipherState) encrypt(ad, plaintext []byte) []byte {
+	if cs.key == nil {
+		return append([]byte(nil), plaintext...)
+	}
+	aead, _ := chacha20poly1305.New(cs.key)
+	out := aead.Seal(nil, cs.nonceBytes(), plaintext, ad)
+	cs.nonce++
+	return out
+}
+
+func (cs *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if cs.key == nil {
+		return append([]byte(nil), ciphertext...), nil
+	}
+	aead, _ := chacha20poly1305.New(cs.key)
+	out, err := aead.Open(nil, cs.nonceBytes(), ciphertext, ad)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	cs.nonce++
+	return out, nil
+}
+
+type symmetricState struct {
+	cipherState
+	ck []byte
+	h  []byte
+}
+
+func newSymmetricState(name string) *symmetricState {
+	var h []byte
+	if len(name) <= sha256.Size {
+		h = make([]byte, sha256.Size)
+		copy(h, name)
+	} else {
+		digest := sha256.Sum256([]byte(name))
+		h = digest[:]
+	}
+	return &symmetricState{ck: append([]byte(nil), h...), h: h}
+}
+
+// hkdf derives n outputs from the chaining key and ikm.
+func (s *symmetricState) hkdf(ikm []byte, n int) [][]byte {
+	r := hkdf.New(sha256.New, ikm, s.ck, nil)
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = make([]byte, sha256.Size)
+		io.ReadFull(r, out[i])
+	}
+	return out
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	digest := sha256.Sum256(append(append([]byte(nil), s.h...), data...))
+	s.h = digest[:]
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	out := s.hkdf(ikm, 2)
+	s.ck = out[0]
+	s.key = out[1]
+	s.nonce = 0
+}
+
+func (s *symmetricState) mixKeyAndHash(ikm []byte) {
+	out := s.hkdf(ikm, 3)
+	s.ck = out[0]
+	s.mixHash(out[1])
+	s.key = out[2]
+	s.nonce = 0
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := s.encrypt(s.h, plaintext)
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.decrypt(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the cipher states of the initiator and the
+// responder.
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	out := s.hkdf(nil, 2)
+	return &cipherState{key: out[0]}, &cipherState{key: out[1]}
+}
+
+// handshakeState runs the XX pattern:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se (, psk)
+type handshakeState struct {
+	*symmetricState
+	config    *Config
+	initiator bool
+
+	e  *ecdh.PrivateKey
+	re *ecdh.PublicKey
+	rs *ecdh.PublicKey
+}
+
+func newHandshakeState(config *Config, initiator bool) *handshakeState {
+	hs := &handshakeState{
+		symmetricState: newSymmetricState(config.protocolName()),
+		config:         config,
+		initiator:      initiator,
+	}
+	hs.mixHash(prologue)
+	return hs
+}
+
+func (hs *handshakeState) dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return err
+	}
+	hs.mixKey(shared)
+	return nil
+}
+
+func (hs *handshakeState) writeE() ([]byte, error) {
+	e, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	hs.e = e
+
+	pub := e.PublicKey().Bytes()
+	hs.mixHash(pub)
+	if hs.config.PSK != nil {
+		hs.mixKey(pub)
+	}
+	return pub, nil
+}
+
+func (hs *handshakeState) readE(msg []byte) ([]byte, error) {
+	if len(msg) < 32 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:32])
+	if err != nil {
+		return nil, err
+	}
+	hs.re = re
+
+	hs.mixHash(msg[:32])
+	if hs.config.PSK != nil {
+		hs.mixKey(msg[:32])
+	}
+	return msg[32:], nil
+}
+
+func (hs *handshakeState) writeS() []byte {
+	return hs.encryptAndHash(hs.config.Static.PublicKey().Bytes())
+}
+
+func (hs *handshakeState) readS(msg []byte) ([]byte, error) {
+	size := 32
+	if hs.key != nil {
+		size += chacha20poly1305.Overhead
+	}
+	if len(msg) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	raw, err := hs.decryptAndHash(msg[:size])
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	if hs.config.Verify != nil {
+		if err := hs.config.Verify(rs); err != nil {
+			return nil, err
+		}
+	}
+	hs.rs = rs
+	return msg[size:], nil
+}
+
+// readPayload checks the empty payload at the end of a message.
+func (hs *handshakeState) readPayload(msg []byte) error {
+	payload, err := hs.decryptAndHash(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) != 0 {
+		return errors.New("unexpected handshake payload")
+	}
+	return nil
+}
+
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// handshake runs the XX pattern on rw and returns the cipher states
+// for sending and receiving and the static key of the peer.
+func handshake(rw io.ReadWriter, config *Config, initiator bool) (*cipherState, *cipherState, *ecdh.PublicKey, error) {
+	hs := newHandshakeState(config, initiator)
+
+	if initiator {
+		// -> e
+		msg, err := hs.writeE()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		msg = append(msg, hs.encryptAndHash(nil)...)
+		if err := writeMessage(rw, msg); err != nil {
+			return nil, nil, nil, err
+		}
+
+		// <- e, ee, s, es
+		if msg, err = readMessage(rw); err != nil {
+			return nil, nil, nil, err
+		}
+		if msg, err = hs.readE(msg); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := hs.dh(hs.e, hs.re); err != nil {
+			return nil, nil, nil, err
+		}
+		if msg, err = hs.readS(msg); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := hs.dh(hs.e, hs.rs); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := hs.readPayload(msg); err != nil {
+			return nil, nil, nil, err
+		}
+
+		// -> s, se (, psk)
+		msg = hs.writeS()
+		if err := hs.dh(config.Static, hs.re); err != nil {
+			return nil, nil, nil, err
+		}
+		if config.PSK != nil {
+			hs.mixKeyAndHash(config.PSK)
+		}
+		msg = append(msg, hs.encryptAndHash(nil)...)
+		if err := writeMessage(rw, msg); err != nil {
+			return nil, nil, nil, err
+		}
+
+		send, recv := hs.split()
+		return send, recv, hs.rs, nil
+	}
+
+	// -> e
+	msg, err := readMessage(rw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if msg, err = hs.readE(msg); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := hs.readPayload(msg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// <- e, ee, s, es
+	if msg, err = hs.writeE(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := hs.dh(hs.e, hs.re); err != nil {
+		return nil, nil, nil, err
+	}
+	msg = append(msg, hs.writeS()...)
+	if err := hs.dh(config.Static, hs.re); err != nil {
+		return nil, nil, nil, err
+	}
+	msg = append(msg, hs.encryptAndHash(nil)...)
+	if err := writeMessage(rw, msg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// -> s, se (, psk)
+	if msg, err = readMessage(rw); err != nil {
+		return nil, nil, nil, err
+	}
+	if msg, err = hs.readS(msg); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := hs.dh(hs.e, hs.rs); err != nil {
+		return nil, nil, nil, err
+	}
+	if config.PSK != nil {
+		hs.mixKeyAndHash(config.PSK)
+	}
+	if err := hs.readPayload(msg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	recv, send := hs.split()
+	return send, recv, hs.rs, nil
+}
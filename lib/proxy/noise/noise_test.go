@@ -0,0 +1,150 @@
+package noise
+
+import (
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+func testKey(t *testing.T) *ecdh.PrivateKey {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pin(pub *ecdh.PublicKey) func(*ecdh.PublicKey) error {
+	return func(peer *ecdh.PublicKey) error {
+		if !peer.Equal(pub) {
+			return ErrPeerNotTrusted
+		}
+		return nil
+	}
+}
+
+// connect runs both sides of the handshake; the server side is
+// driven by a Read().
+func connect(t *testing.T, client, server *Config) (net.Conn, net.Conn, error) {
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+
+	srv := Server(s, server)
+	go srv.(*noiseConn).handshake()
+
+	conn, err := Client(context.Background(), c, client)
+	return conn, srv, err
+}
+
+func TestHandshake(t *testing.T) {
+	var (
+		clientKey = testKey(t)
+		serverKey = testKey(t)
+		psk       = make([]byte, 32)
+	)
+
+	for _, withPSK := range []bool{false, true} {
+		client := &Config{Static: clientKey, Verify: pin(serverKey.PublicKey())}
+		server := &Config{Static: serverKey, Verify: pin(clientKey.PublicKey())}
+		if withPSK {
+			client.PSK, server.PSK = psk, psk
+		}
+
+		conn, srv, err := connect(t, client, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !srv.(*noiseConn).PeerKey().Equal(clientKey.PublicKey()) {
+			t.Fatal("server sees wrong client key")
+		}
+
+		go func() {
+			conn.Write([]byte("secret"))
+			conn.(*noiseConn).finish()
+		}()
+
+		data, err := io.ReadAll(srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "secret" {
+			t.Fatalf("got %q; expected %q", data, "secret")
+		}
+	}
+}
+
+func TestUntrustedPeer(t *testing.T) {
+	client := &Config{Static: testKey(t), Verify: pin(testKey(t).PublicKey())}
+	server := &Config{Static: testKey(t)}
+
+	if _, _, err := connect(t, client, server); !errors.Is(err, ErrPeerNotTrusted) {
+		t.Fatalf("got %v; expected %v", err, ErrPeerNotTrusted)
+	}
+}
+
+func TestPSKMismatch(t *testing.T) {
+	var (
+		client = &Config{Static: testKey(t), PSK: make([]byte, 32)}
+		server = &Config{Static: testKey(t), PSK: make([]byte, 32)}
+	)
+	server.PSK[0] = 1
+
+	_, srv, err := connect(t, client, server)
+	if err != nil {
+		// The client does not notice before its first read.
+		t.Fatal(err)
+	}
+	if srv.(*noiseConn).handshake() == nil {
+		t.Fatal("expected handshake failure")
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	for rawAddr, ok := range map[string]bool{
+		"noise://localhost":               false,
+		"noise://localhost?insecure=true": true,
+		"noise://localhost?fingerprint=0": true,
+	} {
+		addr, err := proxy.ParseAddr(rawAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := proxy.Registry.FindAndCreateProxy(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ParseOptions(desc)
+		if ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", rawAddr, err)
+		}
+		if !ok && !errors.Is(err, proxy.ErrInvalidOption) {
+			t.Errorf("%s: got %v; expected %v", rawAddr, err, proxy.ErrInvalidOption)
+		}
+	}
+}
+
+func TestHandshakeContext(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+
+	// The client never starts the handshake.
+	srv := Server(s, &Config{Static: testKey(t)})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := srv.(*noiseConn).HandshakeContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v; expected %v", err, context.DeadlineExceeded)
+	}
+}
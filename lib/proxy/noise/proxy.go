@@ -0,0 +1,192 @@
+package noise
+
+import (
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"golang.org/x/exp/slices"
+)
+
+var errNotStacked = errors.New("must be stacked on another module, e.g. noise+tcp://")
+
+func ParseOptions(desc *proxy.ProxyDescription) (*Config, error) {
+	var (
+		err          error
+		config       = &Config{}
+		keyPath      = desc.GetStringOption("key_path")
+		pskPath      = desc.GetStringOption("psk_file")
+		fingerprints = desc.GetListOption("fingerprint")
+		insecure     = desc.GetBoolOption("insecure")
+	)
+
+	if len(fingerprints) == 0 && pskPath == "" && !insecure {
+		return nil, &proxy.OptionError{
+			Scheme: desc.Scheme,
+			Key:    "fingerprint",
+			Err:    proxy.ErrInvalidOption,
+			Cause:  errors.New("peers are not authenticated; set fingerprint or psk_file, or insecure=true to accept any peer"),
+		}
+	}
+
+	if keyPath == "" {
+		config.Static, err = GenerateKey()
+		if err == nil {
+			fingerprint := Fingerprint(config.Static.PublicKey())
+			helper.GetLogger().Info("generated key", "sha256", fingerprint)
+			helper.Event(helper.EventCert, "proxy", desc.Scheme, "sha256", fingerprint)
+		}
+	} else {
+		config.Static, err = LoadKey(keyPath)
+		if err == nil {
+			helper.GetLogger().Info("loaded key", "path", keyPath, "sha256", Fingerprint(config.Static.PublicKey()))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if pskPath != "" {
+		if config.PSK, err = LoadPSK(pskPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, fingerprint := range fingerprints {
+		fingerprints[i] = strings.ToLower(fingerprint)
+	}
+
+	config.Verify = func(pub *ecdh.PublicKey) error {
+		fingerprint := Fingerprint(pub)
+		switch {
+		case len(fingerprints) > 0:
+			if !slices.Contains(fingerprints, fingerprint) {
+				return fmt.Errorf("%w: %s", ErrPeerNotTrusted, fingerprint)
+			}
+		case config.PSK == nil:
+			helper.GetLogger().Warn("peer is not authenticated (insecure=true)", "sha256", fingerprint)
+		}
+		helper.Event(helper.EventHandshake, "proxy", desc.Scheme, "peer_sha256", fingerprint)
+		return nil
+	}
+
+	return config, nil
+}
+
+// dialer keeps its config, such that a generated key is used for
+// all connections of the proxy instance.
+type dialer struct {
+	once   sync.Once
+	config *Config
+	err    error
+}
+
+func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	return nil, errNotStacked
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	d.once.Do(func() {
+		d.config, d.err = ParseOptions(desc)
+	})
+	if d.err != nil {
+		return nil, d.err
+	}
+	return Client(ctx, conn, d.config)
+}
+
+type listener struct {
+	ln     net.Listener
+	config *Config
+}
+
+func (ln *listener) IsListening() bool {
+	return ln.ln != nil
+}
+
+func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	return errNotStacked
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	config, err := ParseOptions(desc)
+	if err != nil {
+		return err
+	}
+
+	ln.ln = inner
+	ln.config = config
+
+	return nil
+}
+
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := proxy.AcceptContext(ctx, ln.ln)
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, ln.config), nil
+}
+
+func (ln *listener) Close() error {
+	return ln.ln.Close()
+}
+
+var (
+	StringOptions = []proxy.ProxyOption[string]{
+		{
+			Name:        "key_path",
+			Description: "path to a pem encoded X25519 private key (openssl genpkey -algorithm X25519); a key is generated if empty",
+		},
+		{
+			Name:        "psk_file",
+			Description: "path to a pre-shared key which both peers must know",
+		},
+	}
+	BoolOptions = []proxy.ProxyOption[bool]{
+		{
+			Name:        "insecure",
+			Description: "accept any peer if neither fingerprint nor psk_file is set",
+		},
+	}
+	ListOptions = []proxy.ProxyOption[[]string]{
+		{
+			Name:        "fingerprint",
+			Description: "accept only peers with these publickey fingerprints (SHA256)",
+		},
+	}
+)
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "noise",
+		Description:      "encrypt and authenticate the connection of the module below with the Noise_XX handshake",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy - 'noise+tcp://example.org:1234?fingerprint=<sha256 of the listener>'",
+			"$ gcat proxy - 'noise+ws://example.org/tunnel?psk_file=secret.txt'",
+		},
+		StringOptions: StringOptions,
+		BoolOptions:   BoolOptions,
+		ListOptions:   ListOptions,
+		NewDialer:     func() proxy.ProxyDialer { return &dialer{} },
+	})
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "noise-listen",
+		Description:      "encrypt and authenticate the connections of the listener below with the Noise_XX handshake",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy 'noise-listen+tcp-listen://:1234?key_path=server.pem&fingerprint=<sha256 of the client>' -",
+		},
+		StringOptions: StringOptions,
+		BoolOptions:   BoolOptions,
+		ListOptions:   ListOptions,
+		NewListener:   func() proxy.ProxyListener { return &listener{} },
+	})
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// These options are taken from the URL itself and not from the querystring.
+var urlOptions = map[string]bool{
+	"Hostname": true,
+	"Port":     true,
+	"Path":     true,
+}
+
+type optionParser func(value string) error
+
+func parseBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+func parseInt(base int) optionParser {
+	return func(value string) error {
+		_, err := strconv.ParseInt(value, base, 0)
+		return err
+	}
+}
+
+func parseFloat(value string) error {
+	_, err := strconv.ParseFloat(value, 64)
+	return err
+}
+
+func parseDurationOption(value string) error {
+	_, err := parseDuration(value)
+	return err
+}
+
+func parseSize(value string) error {
+	_, err := ParseSize(value)
+	return err
+}
+
+// parseChoice returns nil if there is nothing to validate.
+func parseChoice(choices []string) optionParser {
+	if len(choices) == 0 {
+		return nil
+	}
+	return func(value string) error {
+		if !slices.Contains(choices, value) {
+			return fmt.Errorf("must be one of: %s", strings.Join(choices, ", "))
+		}
+		return nil
+	}
+}
+
+func parseList(choices []string) optionParser {
+	parse := parseChoice(choices)
+	return func(value string) error {
+		if parse == nil {
+			return nil
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if err := parse(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// declaredOptions collects the query options of all stacked layers.
+func (p *ProxyDescription) declaredOptions() map[string]optionParser {
+	out := make(map[string]optionParser)
+
+	for layer := p; layer != nil; layer = layer.inner {
+		for _, opt := range layer.StringOptions {
+			if !urlOptions[opt.Name] {
+				out[opt.Name] = parseChoice(opt.Choices)
+			}
+		}
+		for _, opt := range layer.BoolOptions {
+			out[opt.Name] = parseBool
+		}
+		for _, opt := range layer.IntOptions {
+			out[opt.Name] = parseInt(intBase(opt))
+		}
+		for _, opt := range layer.FloatOptions {
+			out[opt.Name] = parseFloat
+		}
+		for _, opt := range layer.DurationOptions {
+			out[opt.Name] = parseDurationOption
+		}
+		for _, opt := range layer.SizeOptions {
+			out[opt.Name] = parseSize
+		}
+		for _, opt := range layer.ListOptions {
+			out[opt.Name] = parseList(opt.Choices)
+		}
+	}
+
+	return out
+}
+
+// validateOptions checks every query parameter of the target address
+// against the declared options. It catches typos, such as `skip_verfy`,
+// which would otherwise be silently ignored.
+func (p *ProxyDescription) validateOptions() error {
+	declared := p.declaredOptions()
+
+	// Sort the keys for deterministic error messages.
+	var (
+		query = p.Target().Query()
+		keys  = make([]string, 0, len(query))
+	)
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		parse, ok := declared[key]
+		if !ok {
+			return &OptionError{
+				Scheme:     p.Scheme,
+				Key:        key,
+				Suggestion: suggestOption(key, declared),
+				Err:        ErrUnknownOption,
+			}
+		}
+		if parse == nil {
+			continue
+		}
+		for _, value := range query[key] {
+			if err := parse(value); err != nil {
+				if numErr, ok := err.(*strconv.NumError); ok {
+					err = numErr.Err
+				}
+				return &OptionError{
+					Scheme: p.Scheme,
+					Key:    key,
+					Value:  value,
+					Err:    ErrInvalidOption,
+					Cause:  err,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// suggestOption returns the declared option closest to key or an
+// empty string if nothing is reasonably close.
+func suggestOption(key string, declared map[string]optionParser) string {
+	var (
+		best     string
+		bestDist = 3 // Only suggest names with an edit distance <= 2.
+	)
+
+	for name := range declared {
+		if dist := levenshtein(key, name); dist < bestDist || (dist == bestDist && name < best) {
+			best = name
+			bestDist = dist
+		}
+	}
+
+	return best
+}
+
+func levenshtein(a, b string) int {
+	var (
+		ra   = []rune(a)
+		rb   = []rune(b)
+		prev = make([]int, len(rb)+1)
+		cur  = make([]int, len(rb)+1)
+	)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		url        string
+		err        error
+		suggestion string
+	}{
+		{url: "test://localhost?skip_verify=true&mtu=1400"},
+		{url: "test://localhost?skip_verfy=true", err: ErrUnknownOption, suggestion: "skip_verify"},
+		{url: "test://localhost?Hostname=foo", err: ErrUnknownOption},
+		{url: "test://localhost?completely_different=1", err: ErrUnknownOption},
+		{url: "test://localhost?skip_verify=maybe", err: ErrInvalidOption},
+		{url: "test://localhost?mtu=0x10", err: ErrInvalidOption},
+		{url: "test://localhost?mark=ff"},
+		{url: "test://localhost?mark=fg", err: ErrInvalidOption},
+		{url: "test://localhost?rate=0.05"},
+		{url: "test://localhost?rate=abc", err: ErrInvalidOption},
+	}
+
+	r := newTestRegistry()
+
+	for _, tt := range tests {
+		addr, err := ParseAddr(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.FindAndCreateProxy(addr)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: got error %v; expected %v", tt.url, err, tt.err)
+		}
+
+		var optErr *OptionError
+		if errors.As(err, &optErr) && optErr.Suggestion != tt.suggestion {
+			t.Fatalf("%s: got suggestion %q; expected %q", tt.url, optErr.Suggestion, tt.suggestion)
+		}
+	}
+}
+
+func TestGetIntOption(t *testing.T) {
+	addr, err := ParseAddr("test://localhost?mtu=1400&mark=ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newTestRegistry().FindAndCreateProxy(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := p.GetIntOption("mtu"); v != 1400 {
+		t.Fatalf("got %d; expected 1400", v)
+	}
+	// Values are parsed in the declared base.
+	if v := p.GetIntOption("mark"); v != 0xff {
+		t.Fatalf("got %d; expected 255", v)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Size
+		fails    bool
+	}{
+		{in: "1500", expected: 1500},
+		{in: "64KiB", expected: 64 << 10},
+		{in: "1 MiB", expected: 1 << 20},
+		{in: "2kb", expected: 2000},
+		{in: "10B", expected: 10},
+		{in: "-1", fails: true},
+		{in: "1.5MiB", fails: true},
+		{in: "KiB", fails: true},
+		{in: "9223372036854775807", expected: math.MaxInt64},
+		{in: "9007199254740992KiB", fails: true},
+		{in: "10000000000GB", fails: true},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseSize(tt.in)
+		if tt.fails {
+			if !errors.Is(err, ErrInvalidOption) {
+				t.Fatalf("%s: expected ErrInvalidOption; got %v", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tt.in, err)
+		}
+		if v != tt.expected {
+			t.Fatalf("%s: got %d; expected %d", tt.in, v, tt.expected)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for in, expected := range map[string]Size{
+		"1MiB/s":   1 << 20,
+		"200KiB/S": 200 << 10,
+		"1500":     1500,
+	} {
+		v, err := ParseRate(in)
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+		if v != expected {
+			t.Fatalf("%s: got %d; expected %d", in, v, expected)
+		}
+	}
+
+	if _, err := ParseRate("fast/s"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestListAndEnumOptions(t *testing.T) {
+	r := newTestRegistry()
+	r.Add(ProxyDescription{
+		Scheme:    "enum",
+		NewDialer: newTestDialer,
+		StringOptions: []ProxyOption[string]{
+			{Name: "mode", Choices: []string{"crlf", "lf"}},
+		},
+		ListOptions: []ProxyOption[[]string]{
+			{Name: "proto", Default: []string{"h2"}, Choices: []string{"h2", "http/1.1"}},
+		},
+	})
+
+	addr, err := ParseAddr("enum:?mode=lf&proto=h2,http/1.1&proto=h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.FindAndCreateProxy(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := p.GetListOption("proto"); !slices.Equal(v, []string{"h2", "http/1.1", "h2"}) {
+		t.Fatalf("got unexpected list: %v", v)
+	}
+
+	for _, rawURL := range []string{"enum:?mode=cr", "enum:?proto=h3"} {
+		addr, err := ParseAddr(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.FindAndCreateProxy(addr); !errors.Is(err, ErrInvalidOption) {
+			t.Fatalf("%s: got error %v; expected %v", rawURL, err, ErrInvalidOption)
+		}
+	}
+}
@@ -3,26 +3,49 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"text/template"
+	"time"
 
 	markdown "github.com/MichaelMure/go-term-markdown"
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
 )
 
 type ProxyDialer interface {
 	Dial(ctx context.Context, desc *ProxyDescription) (net.Conn, error)
 }
 
+// ProxyListener is the interface of listening proxy modules. Accept()
+// must return ctx.Err() once ctx is cancelled or its deadline expires.
+// Connections might implement ConnInfoProvider in order to expose
+// their metadata.
 type ProxyListener interface {
 	IsListening() bool
 	Listen(desc *ProxyDescription) error
-	Accept() (net.Conn, error)
+	Accept(ctx context.Context) (net.Conn, error)
 	Close() error
 }
 
+// ProxyConnDialer is implemented by dialers which are able to run
+// on top of a connection established by another proxy module,
+// e.g. `tls+ws://`.
+type ProxyConnDialer interface {
+	DialConn(ctx context.Context, desc *ProxyDescription, conn net.Conn) (net.Conn, error)
+}
+
+// ProxyConnListener is implemented by listeners which are able to
+// serve on top of the connections produced by another proxy module,
+// e.g. `tls-listen+ws-listen://`.
+type ProxyConnListener interface {
+	ListenOn(desc *ProxyDescription, ln net.Listener) error
+}
+
 type ProxyScheme string
 
 func (s ProxyScheme) IsListener() bool {
@@ -32,27 +55,51 @@ func (s ProxyScheme) IsListener() bool {
 	return false
 }
 
+// Layers splits a stacked scheme such as `tls+ws` into its
+// components. The outermost layer comes first.
+func (s ProxyScheme) Layers() []ProxyScheme {
+	var out []ProxyScheme
+	for _, layer := range strings.Split(string(s), "+") {
+		out = append(out, ProxyScheme(layer))
+	}
+	return out
+}
+
 func fixupURL(rawURL string) string {
 	if rawURL == "-" {
 		return "stdio:"
 	}
 
-	if strings.HasPrefix(rawURL, "exec:") && !strings.Contains(rawURL, "?") {
-		cmdEncoded := url.QueryEscape(strings.TrimPrefix(rawURL, "exec:"))
-		return fmt.Sprintf("exec:?cmd=%s", cmdEncoded)
+	// The exec module might be the innermost layer of a stack.
+	scheme, cmd, found := strings.Cut(rawURL, ":")
+	if found && (scheme == "exec" || strings.HasSuffix(scheme, "+exec")) && !strings.Contains(rawURL, "?") {
+		cmdEncoded := url.QueryEscape(cmd)
+		return fmt.Sprintf("%s:?cmd=%s", scheme, cmdEncoded)
 	}
 
 	return rawURL
 }
 
 type ProxyOptionType interface {
-	string | bool | int
+	string | bool | int | float64 | time.Duration | Size | []string
 }
 
 type ProxyOption[T ProxyOptionType] struct {
 	Name        string
 	Description string
 	Default     T
+	// Choices turns string and list options into enums.
+	Choices []string
+	// Base is the base of int options, e.g. 16; 0 means 10.
+	Base int
+}
+
+// intBase returns the base in which the value of opt is written.
+func intBase(opt ProxyOption[int]) int {
+	if opt.Base == 0 {
+		return 10
+	}
+	return opt.Base
 }
 
 type ProxyDescription struct {
@@ -61,21 +108,88 @@ type ProxyDescription struct {
 	Examples         []string
 	SupportsMultiple bool
 	SupportsStreams  bool
+	// Network is set for dialers of remote network addresses; only
+	// those are probed by Probe().
+	Network bool
+
+	StringOptions   []ProxyOption[string]
+	BoolOptions     []ProxyOption[bool]
+	IntOptions      []ProxyOption[int]
+	FloatOptions    []ProxyOption[float64]
+	DurationOptions []ProxyOption[time.Duration]
+	SizeOptions     []ProxyOption[Size]
+	ListOptions     []ProxyOption[[]string]
+
+	// Factories which create a fresh dialer or listener for every
+	// instantiated proxy; thus, instances never share state. The
+	// factories must not have side effects.
+	NewDialer   func() ProxyDialer
+	NewListener func() ProxyListener
+
+	// Reconnect makes Connect() of dialers retry on errors if set.
+	Reconnect *ReconnectPolicy
+	// HandshakeTimeout limits dials and the handshakes of accepted
+	// connections if > 0.
+	HandshakeTimeout time.Duration
+
+	dialer   ProxyDialer
+	listener ProxyListener
+	addr     *ProxyAddr
+	inner    *ProxyDescription
+	// access is the policy of accepted connections; nil allows all.
+	access *helper.AccessPolicy
+}
 
-	StringOptions []ProxyOption[string]
-	BoolOptions   []ProxyOption[bool]
-	IntOptions    []ProxyOption[int]
-
-	Dialer   ProxyDialer
-	Listener ProxyListener
+// IsStackable reports whether the module is able to run on top
+// of another proxy module.
+func (p *ProxyDescription) IsStackable() bool {
+	if p.NewDialer != nil {
+		_, ok := p.NewDialer().(ProxyConnDialer)
+		return ok
+	}
+	if p.NewListener != nil {
+		_, ok := p.NewListener().(ProxyConnListener)
+		return ok
+	}
+	return false
+}
 
-	addr *ProxyAddr
+// Inner returns the proxy module this module is stacked on; nil
+// if the module opens its own connections.
+func (p *ProxyDescription) Inner() *ProxyDescription {
+	return p.inner
 }
 
 func (p *ProxyDescription) IsListener() bool {
 	return p.Target().ProxyScheme().IsListener()
 }
 
+// Probe dials the innermost layer of p once to check whether the
+// target is reachable; upper layers, Reconnect, events and metrics are
+// bypassed. It returns ErrNotSupported for modules which do not dial
+// a network address, e.g. exec, where a probe would spawn a process.
+func (p *ProxyDescription) Probe(ctx context.Context) (net.Conn, error) {
+	base := p
+	for base.inner != nil {
+		base = base.inner
+	}
+	if !base.Network || base.dialer == nil {
+		return nil, fmt.Errorf("%s: probe: %w", base.Scheme, ErrNotSupported)
+	}
+	return base.dialer.Dial(ctx, base)
+}
+
+// instantiate creates the dialer or listener of this proxy instance.
+// If both p.NewDialer and p.NewListener are defined, then
+// p.NewListener is ignored.
+func (p *ProxyDescription) instantiate() {
+	if p.NewDialer != nil {
+		p.dialer = p.NewDialer()
+		return
+	}
+	p.listener = p.NewListener()
+}
+
 func (p *ProxyDescription) SetAddr(addr *ProxyAddr) *ProxyDescription {
 	if addr.ProxyScheme() != p.Scheme {
 		panic(fmt.Sprintf("wrong scheme %s; expected %s", addr.ProxyScheme(), p.Scheme))
@@ -93,6 +207,37 @@ func (p *ProxyDescription) Target() *ProxyAddr {
 	return p.addr
 }
 
+// optionHelp is the representation of a ProxyOption in Help().
+type optionHelp struct {
+	Name        string
+	Description string
+	Default     any
+	Choices     []string
+}
+
+type optionSection struct {
+	Title   string
+	Options []optionHelp
+}
+
+// newOptionSection converts opts for Help(). Choices are only
+// listed for enum types, i.e. string and list options.
+func newOptionSection[T ProxyOptionType](title string, opts []ProxyOption[T], enum bool) optionSection {
+	section := optionSection{Title: title}
+	for _, opt := range opts {
+		o := optionHelp{
+			Name:        opt.Name,
+			Description: opt.Description,
+			Default:     opt.Default,
+		}
+		if enum {
+			o.Choices = opt.Choices
+		}
+		section.Options = append(section.Options, o)
+	}
+	return section
+}
+
 func (ep *ProxyDescription) Help() string {
 	var (
 		builder strings.Builder
@@ -109,58 +254,149 @@ func (ep *ProxyDescription) Help() string {
 
 * SupportsMultipleConnections: ` + "`" + `{{ .SupportsMultiple }}` + "`" + `
 * SupportsStreams: ` + "`" + `{{ .SupportsStreams }}` + "`" + `
+* Stackable: ` + "`" + `{{ .Stackable }}` + "`" + `
 
-## String Options
-{{ if .StringOptions }}
-{{ range .StringOptions }}
-  * ` + "`" + `{{ .Name }}` + "`" + `{{if .Default}} [default: ` + "`" + `{{ .Default }}` + "`" + `]{{end}}: {{ .Description }}{{end}}
-{{ else }}
-no arguments
-{{end}}
-## Int Options
-{{ if .IntOptions }}
-{{ range .IntOptions }}
-  * ` + "`" + `{{ .Name }}` + "`" + `{{if .Default}} [default: ` + "`" + `{{ .Default }}` + "`" + `]{{end}}: {{ .Description }}{{end}}
+{{ range .Sections }}## {{ .Title }}
+{{ if .Options }}
+{{ range .Options }}
+  * ` + "`" + `{{ .Name }}` + "`" + `{{if .Default}} [default: ` + "`" + `{{ .Default }}` + "`" + `]{{end}}: {{ .Description }}{{if .Choices}} (one of: {{ range $i, $c := .Choices }}{{if $i}}, {{end}}` + "`" + `{{ $c }}` + "`" + `{{end}}){{end}}{{end}}
 {{ else }}
 no arguments
 {{end}}
-## Bool Options
-{{ if .BoolOptions }}
-{{ range .BoolOptions }}
-  * ` + "`" + `{{ .Name }}` + "`" + `{{if .Default}} [default: ` + "`" + `{{ .Default }}` + "`" + `]{{end}}: {{ .Description }}{{end}}
-{{ else }}
-no arguments
-{{end}}
-{{ if .Examples }}## Examples
+{{end}}{{ if .Examples }}## Examples
 {{ range .Examples }}
     {{ . }}{{end}}
 {{end}}
 `))
 	)
 
-	if err := tpl.Execute(&builder, *ep); err != nil {
+	data := struct {
+		ProxyDescription
+		Stackable bool
+		Sections  []optionSection
+	}{
+		ProxyDescription: *ep,
+		Stackable:        ep.IsStackable(),
+		Sections: []optionSection{
+			newOptionSection("String Options", ep.StringOptions, true),
+			newOptionSection("Int Options", ep.IntOptions, false),
+			newOptionSection("Float Options", ep.FloatOptions, false),
+			newOptionSection("Bool Options", ep.BoolOptions, false),
+			newOptionSection("Duration Options", ep.DurationOptions, false),
+			newOptionSection("Size Options", ep.SizeOptions, false),
+			newOptionSection("List Options", ep.ListOptions, true),
+		},
+	}
+
+	if err := tpl.Execute(&builder, data); err != nil {
 		panic(err)
 	}
 
 	return string(bytes.TrimSpace(markdown.Render(builder.String(), 80, 2)))
 }
+
+// Connect dials or accepts the next connection. Dialers retry
+// according to p.Reconnect if set.
 func (p *ProxyDescription) Connect(ctx context.Context) (net.Conn, error) {
-	// If both p.Dialer and p.Listener are defined, then p.Listener
-	// is ignored.
-	if dialer := p.Dialer; dialer != nil {
-		return dialer.Dial(ctx, p)
+	if p.Reconnect == nil || p.listener != nil {
+		return p.connectTimeout(ctx)
+	}
+
+	connect := func() (net.Conn, error) {
+		return p.connectTimeout(ctx)
+	}
+	conn, err := p.Reconnect.connectWithBackoff(ctx, connect)
+	if err != nil {
+		return nil, err
 	}
 
-	if ln := p.Listener; ln != nil {
-		if !ln.IsListening() {
-			if err := ln.Listen(p); err != nil {
+	if p.Reconnect.Respawn {
+		return newRespawnConn(ctx, p.Reconnect, conn, p.connectTimeout), nil
+	}
+	return conn, nil
+}
+
+func (p *ProxyDescription) connect(ctx context.Context) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	switch {
+	case p.inner != nil:
+		conn, err = p.connectStacked(ctx)
+	case p.dialer != nil:
+		conn, err = p.dialer.Dial(ctx, p)
+	case p.listener != nil:
+		if !p.listener.IsListening() {
+			access, err := p.AccessPolicy()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.listener.Listen(p); err != nil {
 				return nil, err
 			}
+			p.access = access
+			p.emitListening()
 		}
-		return ln.Accept()
+		conn, err = p.accept(ctx)
+	default:
+		panic("BUG: invalid proxy")
 	}
 
-	panic("BUG: invalid proxy")
+	if err != nil {
+		return nil, err
+	}
+	p.emitConnected(conn)
+
+	return conn, nil
+}
+
+func (p *ProxyDescription) emitListening() {
+	helper.Event(helper.EventListening, "proxy", p.Scheme, "addr", p.Target().String())
+}
+
+// emitConnected counts conn and emits the accepted or dialed event
+// for it as well as the handshake event if conn has completed a TLS
+// handshake already.
+func (p *ProxyDescription) emitConnected(conn net.Conn) {
+	info := GetConnInfo(conn)
+
+	if p.listener != nil {
+		metrics.ConnectionsAccepted.Inc(string(p.Scheme))
+		helper.Event(helper.EventAccepted, "proxy", p.Scheme, "conn", info)
+	} else {
+		metrics.ConnectionsDialed.Inc(string(p.Scheme))
+		helper.Event(helper.EventDialed, "proxy", p.Scheme, "conn", info)
+	}
+
+	if info.TLS != nil && info.TLS.HandshakeComplete {
+		helper.Event(helper.EventHandshake, "proxy", p.Scheme, "conn", info)
+	}
+}
+
+// Close closes the listeners of this proxy and of all layers it
+// is stacked on. Established connections are not affected, except
+// for those of dialers owning resources, such as tun devices, which
+// implement io.Closer and are closed as well.
+func (p *ProxyDescription) Close() error {
+	var errs []error
+
+	for layer := p; layer != nil; layer = layer.inner {
+		if c, ok := layer.dialer.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if ln := layer.listener; ln != nil && ln.IsListening() {
+			// Stacked listeners close their inner listeners as well.
+			if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func (p *ProxyDescription) TargetHost() string {
@@ -206,20 +442,22 @@ func (p *ProxyDescription) GetBoolOption(key string) bool {
 
 	val, err := p.addr.GetBoolOption(key, fallback)
 	if err != nil {
-		panic(err)
+		panic(fmt.Sprintf("BUG: option not validated: %s", err))
 	}
 	return val
 }
 
-func (p *ProxyDescription) GetIntOption(key string, base int) int {
+func (p *ProxyDescription) GetIntOption(key string) int {
 	var (
 		found    = false
 		fallback int
+		base     int
 	)
 
 	for _, opt := range p.IntOptions {
 		if key == opt.Name {
 			fallback = opt.Default
+			base = intBase(opt)
 			found = true
 			break
 		}
@@ -230,7 +468,99 @@ func (p *ProxyDescription) GetIntOption(key string, base int) int {
 
 	val, err := p.addr.GetIntOption(key, base, fallback)
 	if err != nil {
-		panic(err)
+		panic(fmt.Sprintf("BUG: option not validated: %s", err))
+	}
+	return val
+}
+
+func (p *ProxyDescription) GetFloatOption(key string) float64 {
+	var (
+		found    = false
+		fallback float64
+	)
+
+	for _, opt := range p.FloatOptions {
+		if key == opt.Name {
+			fallback = opt.Default
+			found = true
+			break
+		}
+	}
+	if found == false {
+		panic(fmt.Sprintf("BUG: unknown option: %s", key))
+	}
+
+	val, err := p.addr.GetFloatOption(key, fallback)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: option not validated: %s", err))
+	}
+	return val
+}
+
+func (p *ProxyDescription) GetDurationOption(key string) time.Duration {
+	var (
+		found    = false
+		fallback time.Duration
+	)
+
+	for _, opt := range p.DurationOptions {
+		if key == opt.Name {
+			fallback = opt.Default
+			found = true
+			break
+		}
+	}
+	if found == false {
+		panic(fmt.Sprintf("BUG: unknown option: %s", key))
+	}
+
+	val, err := p.addr.GetDurationOption(key, fallback)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: option not validated: %s", err))
 	}
 	return val
 }
+
+func (p *ProxyDescription) GetSizeOption(key string) Size {
+	var (
+		found    = false
+		fallback Size
+	)
+
+	for _, opt := range p.SizeOptions {
+		if key == opt.Name {
+			fallback = opt.Default
+			found = true
+			break
+		}
+	}
+	if found == false {
+		panic(fmt.Sprintf("BUG: unknown option: %s", key))
+	}
+
+	val, err := p.addr.GetSizeOption(key, fallback)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: option not validated: %s", err))
+	}
+	return val
+}
+
+func (p *ProxyDescription) GetListOption(key string) []string {
+	var (
+		found    = false
+		fallback []string
+	)
+
+	for _, opt := range p.ListOptions {
+		if key == opt.Name {
+			fallback = opt.Default
+			found = true
+			break
+		}
+	}
+	if found == false {
+		panic(fmt.Sprintf("BUG: unknown option: %s", key))
+	}
+
+	return p.addr.GetListOption(key, fallback)
+}
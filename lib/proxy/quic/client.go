@@ -13,16 +13,12 @@ import (
 type QUICDialer struct {
 	tlsConfig  *tls.Config
 	quicConfig *quic.Config
+	tracker    connIDTracker
 }
 
 func (p *QUICDialer) Dial(ctx context.Context, prox *proxy.ProxyDescription) (net.Conn, error) {
-	var (
-		stream quic.Stream
-		err    error
-	)
-
 	if p.quicConfig == nil || p.tlsConfig == nil {
-		tlsConfig, quicConfig, err := parseOptions(prox)
+		tlsConfig, quicConfig, err := parseOptions(prox, &p.tracker)
 		if err != nil {
 			return nil, err
 		}
@@ -36,29 +32,41 @@ func (p *QUICDialer) Dial(ctx context.Context, prox *proxy.ProxyDescription) (ne
 		return nil, err
 	}
 
-	if !p.quicConfig.EnableDatagrams {
-		stream, err = conn.OpenStreamSync(ctx)
-		if err != nil {
-			return nil, err
-		}
+	if p.quicConfig.EnableDatagrams {
+		info := p.tracker.connInfo(conn)
+		info.Datagrams = true
+		return &datagramWrapper{
+			conn: conn,
+			info: info,
+		}, nil
 	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(1, err.Error())
+		return nil, err
+	}
+
 	return &streamWrapper{
 		conn:   conn,
 		stream: stream,
+		info:   p.tracker.connInfo(conn),
 	}, nil
 }
 
 func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "quic",
-		Dialer:      &QUICDialer{},
+		NewDialer:   func() proxy.ProxyDialer { return &QUICDialer{} },
+		Network:     true,
 		Description: "connect to a quic host:port and open one stream",
 		Examples: []string{
 			"$ gcat proxy quic://localhost:1234 -",
 		},
 		SupportsMultiple: true,
 		StringOptions:    gtls.StringOptions,
-		IntOptions:       intOptions,
+		ListOptions:      gtls.ListOptions,
+		DurationOptions:  durationOptions,
 		BoolOptions:      append(gtls.BoolOptions, boolOptions...),
 	},
 	)
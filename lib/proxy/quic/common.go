@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
 	"github.com/rumpelsepp/gcat/lib/proxy"
 	gtls "github.com/rumpelsepp/gcat/lib/proxy/tls"
 )
@@ -20,15 +22,48 @@ var (
 			Default:     false,
 		},
 	}
-	intOptions = []proxy.ProxyOption[int]{
+	durationOptions = []proxy.ProxyOption[time.Duration]{
 		{
 			Name:        "keepalive_period",
-			Description: "keepalive interval in seconds",
+			Description: "keepalive interval, e.g. `10s`; disabled if zero",
 		},
 	}
 )
 
-func parseOptions(prox *proxy.ProxyDescription) (*tls.Config, *quic.Config, error) {
+// connIDTracker records the original destination connection IDs
+// of QUIC connections; quic-go only exposes them to tracers.
+type connIDTracker struct {
+	ids sync.Map // tracing id -> quic.ConnectionID
+}
+
+func (t *connIDTracker) tracer(ctx context.Context, p logging.Perspective, connID quic.ConnectionID) *logging.ConnectionTracer {
+	if tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64); ok {
+		t.ids.Store(tracingID, connID)
+	}
+	return nil
+}
+
+func (t *connIDTracker) connInfo(conn quic.Connection) proxy.ConnInfo {
+	var (
+		state = conn.ConnectionState()
+		info  = proxy.ConnInfo{
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			TLS:        &state.TLS,
+			ALPN:       state.TLS.NegotiatedProtocol,
+		}
+	)
+
+	if tracingID, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64); ok {
+		if connID, ok := t.ids.LoadAndDelete(tracingID); ok {
+			info.QUICConnID = connID.(quic.ConnectionID).String()
+		}
+	}
+
+	return info
+}
+
+func parseOptions(prox *proxy.ProxyDescription, tracker *connIDTracker) (*tls.Config, *quic.Config, error) {
 	tlsConfig, err := gtls.ParseOptions(prox)
 	if err != nil {
 		return nil, nil, err
@@ -36,7 +71,8 @@ func parseOptions(prox *proxy.ProxyDescription) (*tls.Config, *quic.Config, erro
 
 	quicConfig := &quic.Config{
 		EnableDatagrams: prox.GetBoolOption("enable_datagrams"),
-		KeepAlivePeriod: time.Duration(prox.GetIntOption("keepalive_period", 10)) * time.Second,
+		KeepAlivePeriod: prox.GetDurationOption("keepalive_period"),
+		Tracer:          tracker.tracer,
 	}
 
 	return tlsConfig, quicConfig, nil
@@ -45,6 +81,11 @@ func parseOptions(prox *proxy.ProxyDescription) (*tls.Config, *quic.Config, erro
 type streamWrapper struct {
 	conn   quic.Connection
 	stream quic.Stream
+	info   proxy.ConnInfo
+}
+
+func (w *streamWrapper) ConnInfo() proxy.ConnInfo {
+	return w.info
 }
 
 func (w *streamWrapper) RemoteAddr() net.Addr {
@@ -63,6 +104,12 @@ func (w *streamWrapper) Write(p []byte) (int, error) {
 	return w.stream.Write(p)
 }
 
+// CloseWrite finishes the sending direction of the stream; QUIC
+// streams are half-closed by Close().
+func (w *streamWrapper) CloseWrite() error {
+	return w.stream.Close()
+}
+
 func (w *streamWrapper) Close() error {
 	if w.stream != nil {
 		if err := w.stream.Close(); err != nil {
@@ -85,12 +132,16 @@ func (w *streamWrapper) SetWriteDeadline(t time.Time) error {
 }
 
 type datagramWrapper struct {
-	ctx  context.Context
 	conn quic.Connection
+	info proxy.ConnInfo
+}
+
+func (w *datagramWrapper) ConnInfo() proxy.ConnInfo {
+	return w.info
 }
 
 func (w *datagramWrapper) Read(p []byte) (int, error) {
-	dgram, err := w.conn.ReceiveMessage(w.ctx)
+	dgram, err := w.conn.ReceiveMessage(w.conn.Context())
 	if err != nil {
 		return 0, err
 	}
@@ -3,7 +3,6 @@ package quic
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
 	"net"
 
 	"github.com/quic-go/quic-go"
@@ -12,9 +11,11 @@ import (
 )
 
 type QUICListener struct {
+	packetConn net.PacketConn
 	listener   *quic.Listener
 	quicConfig *quic.Config
 	tlsConfig  *tls.Config
+	tracker    connIDTracker
 }
 
 func (p *QUICListener) IsListening() bool {
@@ -29,7 +30,7 @@ func (p *QUICListener) Listen(desc *proxy.ProxyDescription) error {
 		return proxy.ErrProxyBusy
 	}
 
-	tlsConfig, quicConfig, err := parseOptions(desc)
+	tlsConfig, quicConfig, err := parseOptions(desc, &p.tracker)
 	if err != nil {
 		return err
 	}
@@ -43,47 +44,58 @@ func (p *QUICListener) Listen(desc *proxy.ProxyDescription) error {
 	}
 
 	quicLn, err := quic.Listen(packetConn, tlsConfig, quicConfig)
-	fmt.Println("listening")
 	if err != nil {
+		packetConn.Close()
 		return err
 	}
 
+	p.packetConn = packetConn
 	p.listener = quicLn
 
 	return nil
 }
 
+// Close closes the UDP socket as well; quic-go leaves sockets
+// passed to quic.Listen() open.
 func (p *QUICListener) Close() error {
-	return p.listener.Close()
+	if !p.IsListening() {
+		return nil
+	}
+	if err := p.listener.Close(); err != nil {
+		return err
+	}
+	return p.packetConn.Close()
 }
 
-func (p *QUICListener) Accept() (net.Conn, error) {
+func (p *QUICListener) Accept(ctx context.Context) (net.Conn, error) {
 	if !p.IsListening() {
 		return nil, proxy.ErrProxyNotInitialized
 	}
 
-	ctx := context.Background()
-
 	conn, err := p.listener.Accept(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	if p.quicConfig.EnableDatagrams {
+		info := p.tracker.connInfo(conn)
+		info.Datagrams = true
 		return &datagramWrapper{
-			ctx:  ctx,
 			conn: conn,
+			info: info,
 		}, nil
 	}
 
 	stream, err := conn.AcceptStream(ctx)
 	if err != nil {
+		conn.CloseWithError(1, err.Error())
 		return nil, err
 	}
 
 	return &streamWrapper{
 		conn:   conn,
 		stream: stream,
+		info:   p.tracker.connInfo(conn),
 	}, nil
 }
 
@@ -95,9 +107,10 @@ func init() {
 			"$ gcat proxy quic-listen://localhost:1234 -",
 		},
 		SupportsMultiple: true,
-		Listener:         &QUICListener{},
+		NewListener:      func() proxy.ProxyListener { return &QUICListener{} },
 		StringOptions:    gtls.StringOptions,
-		IntOptions:       intOptions,
+		ListOptions:      append(gtls.ListOptions, proxy.AccessOptions...),
+		DurationOptions:  durationOptions,
 		BoolOptions:      append(gtls.BoolOptions, boolOptions...),
 	})
 }
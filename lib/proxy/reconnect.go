@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrReconnectFailed = errors.New("reconnect failed")
+
+// ReconnectPolicy makes Connect() of dialers retry with exponential
+// backoff. Listeners are not affected.
+type ReconnectPolicy struct {
+	// MaxAttempts limits the attempts per reconnect; 0 means unlimited.
+	MaxAttempts int
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	// Jitter randomizes each delay by up to this fraction, e.g. 0.2.
+	Jitter float64
+	// Respawn reconnects established connections transparently once
+	// they fail or reach EOF, e.g. to restart an exec'd ssh. Data in
+	// flight might be lost; this is meant for tunnels carrying
+	// packets or protocols which recover on their own.
+	Respawn bool
+	// OnRetry is called before waiting for the next attempt.
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// Delay returns the backoff before the given attempt, starting at 1.
+func (r *ReconnectPolicy) Delay(attempt int) time.Duration {
+	d := r.MinDelay
+	for i := 1; i < attempt && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * r.Jitter * float64(d))
+	}
+	return d
+}
+
+// connectWithBackoff runs connect() until it succeeds, the attempts
+// are exhausted or ctx is done.
+func (r *ReconnectPolicy) connectWithBackoff(ctx context.Context, connect func() (net.Conn, error)) (net.Conn, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := connect()
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if r.MaxAttempts > 0 && attempt >= r.MaxAttempts {
+			return nil, fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, attempt, err)
+		}
+
+		delay := r.Delay(attempt)
+		if r.OnRetry != nil {
+			r.OnRetry(attempt, delay, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// respawnConn replaces its underlying connection with a new one
+// once it fails.
+type respawnConn struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	policy  *ReconnectPolicy
+	connect func(ctx context.Context) (net.Conn, error)
+
+	mutex sync.Mutex
+	conn  net.Conn
+	gen   int
+	since time.Time
+	// Number of connections in a row which broke right away.
+	quick int
+	// The end of a half-closed connection is no failure.
+	halfClosed atomic.Bool
+}
+
+func newRespawnConn(ctx context.Context, policy *ReconnectPolicy, conn net.Conn, connect func(ctx context.Context) (net.Conn, error)) *respawnConn {
+	ctx, cancel := context.WithCancel(ctx)
+	return &respawnConn{
+		ctx:     ctx,
+		cancel:  cancel,
+		policy:  policy,
+		connect: connect,
+		conn:    conn,
+		since:   time.Now(),
+	}
+}
+
+func (c *respawnConn) current() (net.Conn, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.conn, c.gen
+}
+
+// respawn replaces the connection of generation gen unless the
+// other direction has done so already.
+func (c *respawnConn) respawn(gen int, cause error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gen != gen {
+		return nil
+	}
+	if err := c.ctx.Err(); err != nil {
+		return net.ErrClosed
+	}
+
+	c.conn.Close()
+
+	// Connections which break right away, e.g. commands which
+	// exit immediately, are backed off as well.
+	if time.Since(c.since) < c.policy.MaxDelay {
+		c.quick++
+	} else {
+		c.quick = 0
+	}
+	if c.quick > 0 {
+		delay := c.policy.Delay(c.quick)
+		if c.policy.OnRetry != nil {
+			c.policy.OnRetry(c.quick, delay, cause)
+		}
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return net.ErrClosed
+		}
+	}
+
+	conn, err := c.policy.connectWithBackoff(c.ctx, func() (net.Conn, error) {
+		return c.connect(c.ctx)
+	})
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return net.ErrClosed
+		}
+		return err
+	}
+
+	c.conn = conn
+	c.gen++
+	c.since = time.Now()
+
+	return nil
+}
+
+func (c *respawnConn) Read(p []byte) (int, error) {
+	for {
+		conn, gen := c.current()
+
+		n, err := conn.Read(p)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		// Deadlines are set on purpose; these are no failures.
+		if errors.Is(err, os.ErrDeadlineExceeded) || c.halfClosed.Load() {
+			return 0, err
+		}
+		if err := c.respawn(gen, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *respawnConn) Write(p []byte) (int, error) {
+	written := 0
+
+	for {
+		conn, gen := c.current()
+
+		n, err := conn.Write(p)
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return written, err
+		}
+
+		// Write the remainder to the new connection.
+		p = p[n:]
+		if err := c.respawn(gen, err); err != nil {
+			return written, err
+		}
+	}
+}
+
+func (c *respawnConn) Close() error {
+	c.cancel()
+
+	conn, _ := c.current()
+	return conn.Close()
+}
+
+func (c *respawnConn) CloseWrite() error {
+	c.halfClosed.Store(true)
+
+	conn, _ := c.current()
+	if cw, ok := conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNotSupported
+}
+
+func (c *respawnConn) ConnInfo() ConnInfo {
+	conn, _ := c.current()
+	return GetConnInfo(conn)
+}
+
+func (c *respawnConn) LocalAddr() net.Addr {
+	conn, _ := c.current()
+	return conn.LocalAddr()
+}
+
+func (c *respawnConn) RemoteAddr() net.Addr {
+	conn, _ := c.current()
+	return conn.RemoteAddr()
+}
+
+func (c *respawnConn) SetDeadline(t time.Time) error {
+	conn, _ := c.current()
+	return conn.SetDeadline(t)
+}
+
+func (c *respawnConn) SetReadDeadline(t time.Time) error {
+	conn, _ := c.current()
+	return conn.SetReadDeadline(t)
+}
+
+func (c *respawnConn) SetWriteDeadline(t time.Time) error {
+	conn, _ := c.current()
+	return conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	policy := ReconnectPolicy{MinDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, d := range want {
+		if got := policy.Delay(i + 1); got != d {
+			t.Fatalf("attempt %d: got %s; want %s", i+1, got, d)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.Delay(3); got < 320*time.Millisecond || got > 400*time.Millisecond {
+			t.Fatalf("delay %s out of jitter range", got)
+		}
+	}
+}
+
+func TestConnectWithBackoff(t *testing.T) {
+	var (
+		errDial = errors.New("dial failed")
+		retries []int
+		policy  = ReconnectPolicy{
+			MaxAttempts: 3,
+			MinDelay:    time.Millisecond,
+			MaxDelay:    time.Millisecond,
+			OnRetry: func(attempt int, delay time.Duration, err error) {
+				retries = append(retries, attempt)
+			},
+		}
+	)
+
+	attempts := 0
+	connect := func() (net.Conn, error) {
+		if attempts++; attempts < 3 {
+			return nil, errDial
+		}
+		conn, peer := net.Pipe()
+		peer.Close()
+		return conn, nil
+	}
+
+	conn, err := policy.connectWithBackoff(context.Background(), connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if len(retries) != 2 || retries[0] != 1 || retries[1] != 2 {
+		t.Fatalf("unexpected retries %v", retries)
+	}
+
+	fail := func() (net.Conn, error) {
+		return nil, errDial
+	}
+	_, err = policy.connectWithBackoff(context.Background(), fail)
+	if !errors.Is(err, ErrReconnectFailed) || !errors.Is(err, errDial) {
+		t.Fatalf("got error %v; want %v", err, ErrReconnectFailed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy.MaxAttempts = 0
+	if _, err := policy.connectWithBackoff(ctx, fail); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v; want %v", err, context.Canceled)
+	}
+}
+
+// newTestRespawnConn returns a respawnConn of pipes; the peers of
+// the connections are sent to the returned channel.
+func newTestRespawnConn(t *testing.T) (*respawnConn, <-chan net.Conn) {
+	t.Helper()
+
+	peers := make(chan net.Conn, 8)
+	connect := func(ctx context.Context) (net.Conn, error) {
+		conn, peer := net.Pipe()
+		peers <- peer
+		return conn, nil
+	}
+
+	conn, err := connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &ReconnectPolicy{MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	c := newRespawnConn(context.Background(), policy, conn, connect)
+	t.Cleanup(func() { c.Close() })
+
+	return c, peers
+}
+
+func TestRespawnConnEOF(t *testing.T) {
+	c, peers := newTestRespawnConn(t)
+
+	(<-peers).Close()
+
+	// Read() blocks until the new connection delivers data.
+	go func() {
+		peer := <-peers
+		peer.Write([]byte("respawned"))
+	}()
+
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "respawned" {
+		t.Fatalf("got %q", buf)
+	}
+}
+
+func TestRespawnConnDeadline(t *testing.T) {
+	c, peers := newTestRespawnConn(t)
+	<-peers
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got error %v; want %v", err, os.ErrDeadlineExceeded)
+	}
+	if len(peers) != 0 {
+		t.Fatal("connection was respawned after a deadline")
+	}
+}
+
+func TestRespawnConnHalfClose(t *testing.T) {
+	c, peers := newTestRespawnConn(t)
+	peer := <-peers
+
+	// Pipes do not support half-close; the connection counts as
+	// half-closed anyway.
+	if err := c.CloseWrite(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("got error %v; want %v", err, ErrNotSupported)
+	}
+	peer.Close()
+
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("got error %v; want %v", err, io.EOF)
+	}
+	if len(peers) != 0 {
+		t.Fatal("connection was respawned after half-close")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"golang.org/x/exp/maps"
 )
 
+// ProxyRegistry maps schemes to proxy descriptions. The registered
+// descriptions are templates; FindAndCreateProxy() returns an isolated
+// instance for every address.
 type ProxyRegistry struct {
 	data map[ProxyScheme]ProxyDescription
 }
@@ -22,24 +25,56 @@ func (r ProxyRegistry) Get(key ProxyScheme) (ProxyDescription, error) {
 	if v, ok := r.data[key]; ok {
 		return v, nil
 	}
-	return ProxyDescription{}, fmt.Errorf("no such proxy: %s", key)
+	return ProxyDescription{}, fmt.Errorf("%w: %s", ErrNoSuchProxy, key)
 }
 
 func (r *ProxyRegistry) Add(desc ProxyDescription) {
 	if _, ok := r.data[desc.Scheme]; ok {
 		panic(fmt.Sprintf("proxy with scheme %s already registered", desc.Scheme))
 	}
+	if desc.NewDialer == nil && desc.NewListener == nil {
+		panic(fmt.Sprintf("proxy with scheme %s has neither dialer nor listener", desc.Scheme))
+	}
 
 	r.data[desc.Scheme] = desc
 }
 
+// FindAndCreateProxy instantiates the proxy module for addr. Stacked
+// schemes, e.g. `tls+ws`, are resolved from the innermost layer outwards;
+// all layers share the same address and query options. The query options
+// are validated against the options declared by the layers; errors are
+// reported as *OptionError.
 func (r *ProxyRegistry) FindAndCreateProxy(addr *ProxyAddr) (*ProxyDescription, error) {
-	p, err := r.Get(addr.ProxyScheme())
-	if err != nil {
+	var (
+		inner  *ProxyDescription
+		layers = addr.ProxyScheme().Layers()
+	)
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		p, err := r.Get(layers[i])
+		if err != nil {
+			return nil, err
+		}
+
+		p.SetAddr(addr.WithScheme(layers[i]))
+		p.instantiate()
+
+		if inner != nil {
+			if err := p.stackOn(inner); err != nil {
+				return nil, err
+			}
+		}
+		inner = &p
+	}
+
+	if err := inner.validateOptions(); err != nil {
+		return nil, err
+	}
+	if err := inner.validateAccess(); err != nil {
 		return nil, err
 	}
 
-	return p.SetAddr(addr), nil
+	return inner, nil
 }
 
 var Registry = ProxyRegistry{data: make(map[ProxyScheme]ProxyDescription)}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type testDialer struct {
+	dials int
+}
+
+func (d *testDialer) Dial(ctx context.Context, desc *ProxyDescription) (net.Conn, error) {
+	d.dials++
+	return nil, ErrNotImplemented
+}
+
+func newTestDialer() ProxyDialer {
+	return &testDialer{}
+}
+
+func newTestRegistry() *ProxyRegistry {
+	r := &ProxyRegistry{data: make(map[ProxyScheme]ProxyDescription)}
+	r.Add(ProxyDescription{
+		Scheme:    "test",
+		NewDialer: newTestDialer,
+		StringOptions: []ProxyOption[string]{
+			{Name: "Hostname"},
+			{Name: "cert_path"},
+		},
+		BoolOptions: []ProxyOption[bool]{
+			{Name: "skip_verify"},
+		},
+		IntOptions: []ProxyOption[int]{
+			{Name: "mtu", Default: 1500},
+			{Name: "mark", Base: 16},
+		},
+		FloatOptions: []ProxyOption[float64]{
+			{Name: "rate"},
+		},
+	})
+	return r
+}
+
+func TestInstancesAreIsolated(t *testing.T) {
+	r := newTestRegistry()
+
+	addr, err := ParseAddr("test://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err := r.FindAndCreateProxy(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := r.FindAndCreateProxy(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p1.dialer == p2.dialer {
+		t.Fatal("proxy instances share the same dialer")
+	}
+
+	if _, err := p1.Connect(context.Background()); !errors.Is(err, ErrNotImplemented) {
+		t.Fatalf("expected ErrNotImplemented; got %v", err)
+	}
+	if n := p1.dialer.(*testDialer).dials; n != 1 {
+		t.Fatalf("first instance dialed %d times", n)
+	}
+	if n := p2.dialer.(*testDialer).dials; n != 0 {
+		t.Fatalf("second instance dialed %d times", n)
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is an amount of bytes, e.g. `1500`, `64KiB` or `1MB`.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor Size
+}{
+	// Longest suffixes first; otherwise `KiB` matches `B`.
+	{"kib", 1 << 10},
+	{"mib", 1 << 20},
+	{"gib", 1 << 30},
+	{"kb", 1000},
+	{"mb", 1000 * 1000},
+	{"gb", 1000 * 1000 * 1000},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+func ParseSize(s string) (Size, error) {
+	var (
+		num    = strings.TrimSpace(s)
+		factor = Size(1)
+	)
+
+	lower := strings.ToLower(num)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			num = strings.TrimSpace(num[:len(num)-len(unit.suffix)])
+			factor = unit.factor
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid size: %s", ErrInvalidOption, s)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("%w: negative size: %s", ErrInvalidOption, s)
+	}
+	if Size(v) > math.MaxInt64/factor {
+		return 0, fmt.Errorf("%w: size overflows int64: %s", ErrInvalidOption, s)
+	}
+
+	return Size(v) * factor, nil
+}
+
+func (s Size) String() string {
+	switch {
+	case s != 0 && s%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", s/(1<<30))
+	case s != 0 && s%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", s/(1<<20))
+	case s != 0 && s%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", s/(1<<10))
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// ParseRate parses a rate in bytes per second, e.g. `1MiB/s` or
+// `200KiB`; the `/s` suffix is optional.
+func ParseRate(s string) (Size, error) {
+	num := strings.TrimSpace(s)
+	if lower := strings.ToLower(num); strings.HasSuffix(lower, "/s") {
+		num = num[:len(num)-len("/s")]
+	}
+
+	rate, err := ParseSize(num)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	return rate, nil
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	ErrNotStackable = errors.New("proxy cannot be stacked")
+	ErrInvalidStack = errors.New("invalid proxy stack")
+)
+
+// innerListener exposes the connections of a stacked proxy module
+// as a net.Listener. Every Accept() runs Connect() on the inner
+// listening module.
+type innerListener struct {
+	desc *ProxyDescription
+}
+
+// Accept blocks until the inner module accepts a connection or is
+// closed. Use AcceptContext() for cancellation.
+func (l *innerListener) Accept() (net.Conn, error) {
+	return l.desc.Connect(context.Background())
+}
+
+// AcceptContext is picked up by AcceptContext() and passes ctx of the
+// current accept call to the inner module.
+func (l *innerListener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	return l.desc.Connect(ctx)
+}
+
+func (l *innerListener) Close() error {
+	if ln := l.desc.listener; ln != nil && ln.IsListening() {
+		return ln.Close()
+	}
+	return nil
+}
+
+func (l *innerListener) Addr() net.Addr {
+	return l.desc.Target()
+}
+
+func (p *ProxyDescription) stackOn(inner *ProxyDescription) error {
+	if !p.IsStackable() {
+		return fmt.Errorf("%s: %w", p.Scheme, ErrNotStackable)
+	}
+	// A listener would "accept" by dialing the inner module in a loop.
+	if p.listener != nil && inner.listener == nil {
+		return fmt.Errorf("%s: %w: cannot listen on top of dialer %s", p.Scheme, ErrInvalidStack, inner.Scheme)
+	}
+	p.inner = inner
+	p.SupportsMultiple = p.SupportsMultiple && inner.SupportsMultiple
+	return nil
+}
+
+func (p *ProxyDescription) connectStacked(ctx context.Context) (net.Conn, error) {
+	if dialer := p.dialer; dialer != nil {
+		conn, err := p.inner.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		outerConn, err := dialer.(ProxyConnDialer).DialConn(ctx, p, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return outerConn, nil
+	}
+
+	if ln := p.listener; ln != nil {
+		if !ln.IsListening() {
+			inner := &innerListener{desc: p.inner}
+			if err := ln.(ProxyConnListener).ListenOn(p, inner); err != nil {
+				return nil, err
+			}
+			p.emitListening()
+		}
+		return ln.Accept(ctx)
+	}
+
+	panic("BUG: invalid proxy")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type testListener struct {
+	ln net.Listener
+}
+
+func (l *testListener) IsListening() bool { return l.ln != nil }
+
+func (l *testListener) Listen(desc *ProxyDescription) error { return ErrNotImplemented }
+
+func (l *testListener) ListenOn(desc *ProxyDescription, inner net.Listener) error {
+	l.ln = inner
+	return nil
+}
+
+func (l *testListener) Accept(ctx context.Context) (net.Conn, error) {
+	return AcceptContext(ctx, l.ln)
+}
+
+func (l *testListener) Close() error { return l.ln.Close() }
+
+func TestStackListenerOnDialer(t *testing.T) {
+	r := newTestRegistry()
+	r.Add(ProxyDescription{
+		Scheme:      "test-listen",
+		NewListener: func() ProxyListener { return &testListener{} },
+	})
+
+	addr, err := ParseAddr("test-listen+test://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.FindAndCreateProxy(addr); !errors.Is(err, ErrInvalidStack) {
+		t.Fatalf("expected ErrInvalidStack; got %v", err)
+	}
+}
+
+func TestStackedAccessOptions(t *testing.T) {
+	r := newTestRegistry()
+	newListener := func() ProxyListener { return &testListener{} }
+	r.Add(ProxyDescription{
+		Scheme:      "test-listen",
+		NewListener: newListener,
+	})
+	r.Add(ProxyDescription{
+		Scheme:      "acl-listen",
+		NewListener: newListener,
+		ListOptions: AccessOptions,
+	})
+	r.Add(ProxyDescription{
+		Scheme:      "wrap-listen",
+		NewListener: newListener,
+		ListOptions: AccessOptions,
+	})
+
+	tests := []struct {
+		url   string
+		fails bool
+	}{
+		{url: "wrap-listen+test-listen://localhost?allow=10.0.0.0/8", fails: true},
+		{url: "wrap-listen+test-listen://localhost?deny=10.0.0.1", fails: true},
+		{url: "wrap-listen+test-listen://localhost"},
+		{url: "wrap-listen+acl-listen://localhost?allow=10.0.0.0/8"},
+		{url: "acl-listen://localhost?allow=10.0.0.0/8"},
+	}
+
+	for _, tt := range tests {
+		addr, err := ParseAddr(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.FindAndCreateProxy(addr)
+		if tt.fails {
+			if !errors.Is(err, ErrInvalidOption) {
+				t.Fatalf("%s: expected ErrInvalidOption; got %v", tt.url, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tt.url, err)
+		}
+	}
+}
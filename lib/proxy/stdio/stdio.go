@@ -20,18 +20,18 @@ type stdioWrapper struct {
 	closed bool
 }
 
-func newStdioWrapper() *stdioWrapper {
+func newStdioWrapper() (*stdioWrapper, error) {
 	if err := unix.SetNonblock(unix.Stdin, true); err != nil {
-		panic(err)
+		return nil, err
 	}
 	if err := unix.SetNonblock(unix.Stdout, true); err != nil {
-		panic(err)
+		return nil, err
 	}
 	return &stdioWrapper{
 		stdin:  os.NewFile(uintptr(unix.Stdin), "/dev/stdin"),
 		stdout: os.NewFile(uintptr(unix.Stdout), "/dev/stdout"),
 		closed: false,
-	}
+	}, nil
 }
 
 func (w *stdioWrapper) Read(p []byte) (int, error) {
@@ -76,26 +76,34 @@ func (w *stdioWrapper) Close() error {
 	return nil
 }
 
+// stdioDialer hands out the same wrapper for every Dial(); the
+// wrapper is created lazily since stdio is switched to nonblocking mode.
 type stdioDialer struct {
-	stdioWrapper
+	stdioWrapper *stdioWrapper
 }
 
 func (p *stdioDialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	if p.stdioWrapper == nil {
+		w, err := newStdioWrapper()
+		if err != nil {
+			return nil, err
+		}
+		p.stdioWrapper = w
+	}
+
 	if p.stdioWrapper.closed {
 		if err := p.stdioWrapper.Open(); err != nil {
 			return nil, err
 		}
 	}
-	return &p.stdioWrapper, nil
+	return p.stdioWrapper, nil
 }
 
 func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "stdio",
 		Description: "use stdio; shortcut is `-`",
-		Dialer: &stdioDialer{
-			stdioWrapper: *newStdioWrapper(),
-		},
+		NewDialer:   func() proxy.ProxyDialer { return &stdioDialer{} },
 		Examples: []string{
 			"$ gcat proxy tcp-listen://localhost:1234 stdio:",
 			"$ gcat proxy tcp-listen://localhost:1234 -",
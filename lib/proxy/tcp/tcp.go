@@ -38,11 +38,11 @@ func (p *listener) Listen(desc *proxy.ProxyDescription) error {
 	return nil
 }
 
-func (p *listener) Accept() (net.Conn, error) {
+func (p *listener) Accept(ctx context.Context) (net.Conn, error) {
 	if !p.IsListening() {
 		return nil, proxy.ErrProxyNotInitialized
 	}
-	return p.listener.Accept()
+	return proxy.AcceptContext(ctx, p.listener)
 }
 
 func (p *listener) Close() error {
@@ -56,11 +56,12 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "tcp",
 		Description:      "connect to a tcp host:port",
+		Network:          true,
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat proxy tcp://localhost:1234 -",
 		},
-		Dialer: &dialer{},
+		NewDialer: func() proxy.ProxyDialer { return &dialer{} },
 		StringOptions: []proxy.ProxyOption[string]{
 			{
 				Name:        "Hostname",
@@ -80,8 +81,10 @@ func init() {
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat proxy tcp-listen://localhost:1234 -",
+			"$ gcat proxy 'tcp-listen://0.0.0.0:1234?allow=10.0.0.0/8&deny=10.0.0.1' -",
 		},
-		Listener: &listener{},
+		NewListener: func() proxy.ProxyListener { return &listener{} },
+		ListOptions: proxy.AccessOptions,
 		StringOptions: []proxy.ProxyOption[string]{
 			{
 				Name:        "Hostname",
@@ -0,0 +1,113 @@
+package text
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/rumpelsepp/gcat/lib/proxy"
+)
+
+var errNotStacked = errors.New("must be stacked on another module, e.g. text+tcp://")
+
+type dialer struct{}
+
+func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
+	return nil, errNotStacked
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	opts, err := ParseOptions(desc)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, opts), nil
+}
+
+type listener struct {
+	ln   net.Listener
+	opts Options
+}
+
+func (ln *listener) IsListening() bool {
+	return ln.ln != nil
+}
+
+func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	return errNotStacked
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	opts, err := ParseOptions(desc)
+	if err != nil {
+		return err
+	}
+
+	ln.ln = inner
+	ln.opts = opts
+
+	return nil
+}
+
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := proxy.AcceptContext(ctx, ln.ln)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(conn, ln.opts), nil
+}
+
+func (ln *listener) Close() error {
+	return ln.ln.Close()
+}
+
+var (
+	StringOptions = []proxy.ProxyOption[string]{
+		{
+			Name:        "eol",
+			Description: "line ending of the endpoint; written LFs are converted to it and read ones back to LF",
+			Default:     EOLLF,
+			Choices:     []string{EOLLF, EOLCRLF, EOLCR},
+		},
+		{
+			Name:        "charset",
+			Description: "character encoding of the endpoint, e.g. ISO-8859-1 or IBM437; gcat uses UTF-8",
+		},
+	}
+	BoolOptions = []proxy.ProxyOption[bool]{
+		{
+			Name:        "strip_cr",
+			Description: "remove carriage returns from read data which are not part of a line ending",
+		},
+		{
+			Name:        "line_buffer",
+			Description: "hold written data until a line is complete",
+		},
+	}
+)
+
+func init() {
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "text",
+		Description:      "convert line endings and encodings of the module below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy - 'text+tcp://192.168.1.10:23?eol=crlf'",
+			"$ gcat proxy - 'text+exec:?cmd=picocom+/dev/ttyUSB0&eol=cr&charset=IBM437'",
+		},
+		StringOptions: StringOptions,
+		BoolOptions:   BoolOptions,
+		NewDialer:     func() proxy.ProxyDialer { return &dialer{} },
+	})
+	proxy.Registry.Add(proxy.ProxyDescription{
+		Scheme:           "text-listen",
+		Description:      "convert line endings and encodings of the listener below",
+		SupportsMultiple: true,
+		Examples: []string{
+			"$ gcat proxy 'text-listen+tcp-listen://:2323?eol=crlf&line_buffer=true' -",
+		},
+		StringOptions: StringOptions,
+		BoolOptions:   BoolOptions,
+		NewListener:   func() proxy.ProxyListener { return &listener{} },
+	})
+}
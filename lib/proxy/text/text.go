@@ -0,0 +1,307 @@
+// Package text implements a proxy layer which converts line endings
+// and character encodings, similar to the crnl option of socat.
+package text
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/proxy"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// Line endings of endpoints.
+const (
+	EOLLF   = "lf"
+	EOLCRLF = "crlf"
+	EOLCR   = "cr"
+)
+
+// maxLine is the size of a partial line which is written although
+// line buffering is enabled.
+const maxLine = 64 * 1024
+
+// Options describe the text conventions of an endpoint. gcat itself
+// uses LF line endings and UTF-8.
+type Options struct {
+	// EOL is the line ending of the endpoint: lf, crlf or cr.
+	EOL string
+	// StripCR removes carriage returns from read data which are not
+	// part of a line ending.
+	StripCR bool
+	// Charset is the character encoding of the endpoint; nil means
+	// UTF-8.
+	Charset encoding.Encoding
+	// LineBuffer holds written data until a line is complete.
+	LineBuffer bool
+}
+
+// toLF converts the line endings of read data to LF.
+type toLF struct {
+	eol     string
+	stripCR bool
+	// A CR was seen at the end of the previous chunk.
+	cr bool
+}
+
+func (t *toLF) Reset() {
+	t.cr = false
+}
+
+func (t *toLF) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	var nDst, nSrc int
+
+	for nSrc < len(src) {
+		// A pending CR and the current byte might be emitted.
+		if len(dst)-nDst < 2 {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+
+		b := src[nSrc]
+		nSrc++
+
+		if t.cr {
+			t.cr = false
+			if b == '\n' {
+				// CRLF in crlf mode, or the LF of CRLF in cr
+				// mode which already emitted a LF for the CR.
+				if t.eol == EOLCRLF {
+					dst[nDst] = '\n'
+					nDst++
+				}
+				continue
+			}
+			if t.eol == EOLCRLF && !t.stripCR {
+				dst[nDst] = '\r'
+				nDst++
+			}
+		}
+
+		if b == '\r' {
+			switch {
+			case t.eol == EOLCR:
+				dst[nDst] = '\n'
+				nDst++
+				t.cr = true
+			case t.eol == EOLCRLF:
+				t.cr = true
+			case !t.stripCR:
+				dst[nDst] = b
+				nDst++
+			}
+			continue
+		}
+
+		dst[nDst] = b
+		nDst++
+	}
+
+	if atEOF && t.cr {
+		t.cr = false
+		if t.eol == EOLCRLF && !t.stripCR {
+			if len(dst)-nDst < 1 {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = '\r'
+			nDst++
+		}
+	}
+
+	return nDst, nSrc, nil
+}
+
+// fromLF converts LF line endings of written data to the line
+// ending of the endpoint. Existing CRLFs are kept.
+type fromLF struct {
+	eol  string
+	last byte
+}
+
+func (t *fromLF) Reset() {
+	t.last = 0
+}
+
+func (t *fromLF) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	var nDst, nSrc int
+
+	for nSrc < len(src) {
+		if len(dst)-nDst < 2 {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+
+		b := src[nSrc]
+		nSrc++
+
+		switch {
+		case b != '\n':
+			dst[nDst] = b
+			nDst++
+		case t.last == '\r' && t.eol == EOLCR:
+			// CRLF becomes CR.
+		case t.last == '\r':
+			dst[nDst] = '\n'
+			nDst++
+		case t.eol == EOLCRLF:
+			dst[nDst], dst[nDst+1] = '\r', '\n'
+			nDst += 2
+		case t.eol == EOLCR:
+			dst[nDst] = '\r'
+			nDst++
+		default:
+			dst[nDst] = '\n'
+			nDst++
+		}
+		t.last = b
+	}
+
+	return nDst, nSrc, nil
+}
+
+// Wrap converts the data of conn according to opts.
+func Wrap(conn net.Conn, opts Options) net.Conn {
+	var (
+		readers []transform.Transformer
+		writers []transform.Transformer
+	)
+
+	if opts.Charset != nil {
+		readers = append(readers, opts.Charset.NewDecoder())
+	}
+	if opts.EOL != EOLLF || opts.StripCR {
+		readers = append(readers, &toLF{eol: opts.EOL, stripCR: opts.StripCR})
+	}
+	if opts.EOL != EOLLF {
+		writers = append(writers, &fromLF{eol: opts.EOL})
+	}
+	if opts.Charset != nil {
+		writers = append(writers, opts.Charset.NewEncoder())
+	}
+
+	c := &textConn{Conn: conn, r: conn, w: conn, lineBuffer: opts.LineBuffer}
+	if len(readers) > 0 {
+		c.r = transform.NewReader(conn, transform.Chain(readers...))
+	}
+	if len(writers) > 0 {
+		c.tw = transform.NewWriter(conn, transform.Chain(writers...))
+		c.w = c.tw
+	}
+	return c
+}
+
+type textConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+	// The transforming writer if any; it holds incomplete
+	// sequences until it is closed.
+	tw *transform.Writer
+
+	mutex      sync.Mutex
+	lineBuffer bool
+	buf        []byte
+	flushed    bool
+}
+
+func (c *textConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *textConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.lineBuffer {
+		return c.w.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+
+	n := bytes.LastIndexByte(c.buf, '\n') + 1
+	if len(c.buf) >= maxLine {
+		n = len(c.buf)
+	}
+	if n > 0 {
+		if _, err := c.w.Write(c.buf[:n]); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[n:]...)
+	}
+
+	return len(p), nil
+}
+
+// flush writes a partial line and the state of the transformers.
+func (c *textConn) flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.flushed {
+		return nil
+	}
+	c.flushed = true
+
+	if len(c.buf) > 0 {
+		if _, err := c.w.Write(c.buf); err != nil {
+			return err
+		}
+		c.buf = nil
+	}
+	if c.tw != nil {
+		return c.tw.Close()
+	}
+	return nil
+}
+
+func (c *textConn) CloseWrite() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(helper.CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return proxy.ErrNotSupported
+}
+
+func (c *textConn) Close() error {
+	c.flush()
+	return c.Conn.Close()
+}
+
+func (c *textConn) ConnInfo() proxy.ConnInfo {
+	info := proxy.GetConnInfo(c.Conn)
+	info.Datagrams = false
+	return info
+}
+
+func ParseOptions(desc *proxy.ProxyDescription) (Options, error) {
+	opts := Options{
+		EOL:        desc.GetStringOption("eol"),
+		StripCR:    desc.GetBoolOption("strip_cr"),
+		LineBuffer: desc.GetBoolOption("line_buffer"),
+	}
+
+	if name := desc.GetStringOption("charset"); name != "" {
+		enc, err := ianaindex.IANA.Encoding(name)
+		if err == nil && enc == nil {
+			err = fmt.Errorf("unsupported charset")
+		}
+		if err != nil {
+			return opts, &proxy.OptionError{
+				Scheme: desc.Scheme,
+				Key:    "charset",
+				Value:  name,
+				Err:    proxy.ErrInvalidOption,
+				Cause:  err,
+			}
+		}
+		opts.Charset = enc
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,33 @@
+package text
+
+import (
+	"testing"
+
+	"golang.org/x/text/transform"
+)
+
+func TestLineEndings(t *testing.T) {
+	tests := []struct {
+		name     string
+		t        transform.Transformer
+		in       string
+		expected string
+	}{
+		{"to crlf", &fromLF{eol: EOLCRLF}, "a\nb\r\nc", "a\r\nb\r\nc"},
+		{"to cr", &fromLF{eol: EOLCR}, "a\nb\r\n", "a\rb\r"},
+		{"from crlf", &toLF{eol: EOLCRLF}, "a\r\nb\rc\r", "a\nb\rc\r"},
+		{"from crlf stripped", &toLF{eol: EOLCRLF, stripCR: true}, "a\r\r\nb\rc\r", "a\nbc"},
+		{"from cr", &toLF{eol: EOLCR}, "a\rb\r\nc", "a\nb\nc"},
+		{"strip cr", &toLF{eol: EOLLF, stripCR: true}, "a\r\nb\r", "a\nb"},
+	}
+
+	for _, tt := range tests {
+		out, _, err := transform.String(tt.t, tt.in)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if out != tt.expected {
+			t.Fatalf("%s: got %q; expected %q", tt.name, out, tt.expected)
+		}
+	}
+}
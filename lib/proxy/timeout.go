@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
+)
+
+type handshaker interface {
+	HandshakeContext(ctx context.Context) error
+}
+
+// connectTimeout limits connect() to p.HandshakeTimeout. Dials are
+// raced against the timeout instead of getting a derived context;
+// such a context would kill e.g. exec'd commands once it ends.
+// Listeners wait for clients without limits; only the handshake of
+// accepted TLS connections is limited.
+func (p *ProxyDescription) connectTimeout(ctx context.Context) (conn net.Conn, err error) {
+	if p.listener == nil {
+		defer func() {
+			if err != nil {
+				metrics.DialErrors.Inc(string(p.Scheme), dialErrorType(err))
+			}
+		}()
+	}
+
+	timeout := p.HandshakeTimeout
+	if timeout <= 0 {
+		return p.connect(ctx)
+	}
+
+	if p.listener != nil {
+		conn, err := p.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if h, ok := conn.(handshaker); ok {
+			hctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if err := h.HandshakeContext(hctx); err != nil {
+				conn.Close()
+				if errors.Is(err, context.DeadlineExceeded) {
+					return nil, fmt.Errorf("%s: %w", p.Scheme, helper.ErrHandshakeTimeout)
+				}
+				return nil, err
+			}
+			helper.Event(helper.EventHandshake, "proxy", p.Scheme, "conn", GetConnInfo(conn))
+		}
+		return conn, nil
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := p.connect(ctx)
+		ch <- result{conn, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-timer.C:
+		// Do not leak a connection which arrives too late.
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("%s: %w", p.Scheme, helper.ErrHandshakeTimeout)
+	}
+}
+
+func dialErrorType(err error) string {
+	if errors.Is(err, helper.ErrHandshakeTimeout) {
+		return "timeout"
+	}
+	return metrics.ErrorType(err)
+}
@@ -40,10 +40,12 @@ var (
 			Name:        "fingerprint",
 			Description: "pin to this publickey fingerprint (SHA256)",
 		},
+	}
+	ListOptions = []proxy.ProxyOption[[]string]{
 		{
 			Name:        "next_proto",
-			Description: "value to use in the ALPN field",
-			Default:     "quic",
+			Description: "values to use in the ALPN field",
+			Default:     []string{"quic"},
 		},
 	}
 	BoolOptions = []proxy.ProxyOption[bool]{
@@ -87,8 +89,12 @@ func ParseOptions(desc *proxy.ProxyDescription) (*tls.Config, error) {
 	if desc.IsListener() {
 		if keyPath == "" || certPath == "" {
 			cert, err = helper.GenTLSCertificate()
-			digest := sha256.Sum256(cert.Certificate[0])
-			fmt.Printf("generated cert: %s\n", hex.EncodeToString(digest[:]))
+			if err == nil {
+				digest := sha256.Sum256(cert.Certificate[0])
+				fingerprint := hex.EncodeToString(digest[:])
+				helper.GetLogger().Info("generated certificate", "sha256", fingerprint)
+				helper.Event(helper.EventCert, "proxy", desc.Scheme, "sha256", fingerprint)
+			}
 		} else {
 			cert, err = tls.LoadX509KeyPair(certPath, keyPath)
 		}
@@ -123,7 +129,7 @@ func ParseOptions(desc *proxy.ProxyDescription) (*tls.Config, error) {
 		tlsConfig = &tls.Config{
 			Certificates:          []tls.Certificate{cert},
 			InsecureSkipVerify:    skipVerify,
-			NextProtos:            []string{desc.GetStringOption("next_proto")},
+			NextProtos:            desc.GetListOption("next_proto"),
 			KeyLogWriter:          keylogWriter,
 			VerifyPeerCertificate: verifier,
 			ClientAuth:            clientAuth,
@@ -11,18 +11,32 @@ import (
 type dialer struct{}
 
 func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
-	tlsConfig, err := ParseOptions(desc)
+	dialer := net.Dialer{}
+	tcpConn, err := dialer.DialContext(ctx, "tcp", desc.TargetHost())
 	if err != nil {
 		return nil, err
 	}
 
-	dialer := net.Dialer{}
-	tcpConn, err := dialer.DialContext(ctx, "tcp", desc.TargetHost())
+	tlsConn, err := d.DialConn(ctx, desc, tcpConn)
 	if err != nil {
+		tcpConn.Close()
 		return nil, err
 	}
 
-	tlsConn := tls.Client(tcpConn, tlsConfig)
+	return tlsConn, nil
+}
+
+func (d *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	tlsConfig, err := ParseOptions(desc)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = desc.GetStringOption("Hostname")
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
 	if err := tlsConn.HandshakeContext(ctx); err != nil {
 		return nil, err
 	}
@@ -42,23 +56,32 @@ func (ln *listener) IsListening() bool {
 }
 
 func (ln *listener) Listen(prox *proxy.ProxyDescription) error {
-	tlsConfig, err := ParseOptions(prox)
+	tcpListener, err := net.Listen("tcp", prox.TargetHost())
 	if err != nil {
 		return err
 	}
 
-	tlsListener, err := tls.Listen("tcp", prox.TargetHost(), tlsConfig)
+	if err := ln.ListenOn(prox, tcpListener); err != nil {
+		tcpListener.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (ln *listener) ListenOn(prox *proxy.ProxyDescription, inner net.Listener) error {
+	tlsConfig, err := ParseOptions(prox)
 	if err != nil {
 		return err
 	}
 
-	ln.ln = tlsListener
+	ln.ln = tls.NewListener(inner, tlsConfig)
 
 	return nil
 }
 
-func (ln *listener) Accept() (net.Conn, error) {
-	return ln.ln.Accept()
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
+	return proxy.AcceptContext(ctx, ln.ln)
 }
 
 func (ln *listener) Close() error {
@@ -69,21 +92,26 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "tls",
 		Description: "dial to a tls host",
+		Network:     true,
 		Examples: []string{
 			"$ gcat tls://google.de:443 -",
+			"$ gcat tls+ws://localhost:8080/tunnel -",
 		},
 		StringOptions: StringOptions,
 		BoolOptions:   BoolOptions,
-		Dialer:        &dialer{},
+		ListOptions:   ListOptions,
+		NewDialer:     func() proxy.ProxyDialer { return &dialer{} },
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "tls-listen",
 		Description: "spawn a tls listener",
 		Examples: []string{
 			"$ gcat tls-listen://127.0.0.1:1234 -",
+			"$ gcat tls-listen+ws-listen://127.0.0.1:8080/tunnel -",
 		},
 		StringOptions: StringOptions,
 		BoolOptions:   BoolOptions,
-		Listener:      &listener{},
+		ListOptions:   append(ListOptions, proxy.AccessOptions...),
+		NewListener:   func() proxy.ProxyListener { return &listener{} },
 	})
 }
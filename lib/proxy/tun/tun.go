@@ -2,9 +2,11 @@ package tun
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/rumpelsepp/gcat/lib/proxy"
 )
@@ -17,15 +19,18 @@ type tunDevice interface {
 	AddAddressCIDR(addrCIDR string) error
 }
 
+// dialer keeps track of the allocated devices; sessions which do
+// not end normally would leave their links behind otherwise.
 type dialer struct {
-	tunDevice
+	mu      sync.Mutex
+	devices []tunDevice
 }
 
 func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
 	var (
 		ip   = desc.GetStringOption("Hostname")
 		mask = strings.TrimPrefix(desc.GetStringOption("Path"), "/")
-		mtu  = desc.GetIntOption("mtu", 10)
+		mtu  = desc.GetSizeOption("mtu")
 		dev  = desc.GetStringOption("dev")
 	)
 
@@ -41,18 +46,42 @@ func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Co
 		return nil, err
 	}
 
-	if err := tun.AddAddressCIDR(fmt.Sprintf("%s/%s", ip, mask)); err != nil {
+	if err := setupDevice(tun, fmt.Sprintf("%s/%s", ip, mask), int(mtu)); err != nil {
+		tun.Close()
 		return nil, err
 	}
 
+	d.mu.Lock()
+	d.devices = append(d.devices, tun)
+	d.mu.Unlock()
+
+	return tun, nil
+}
+
+func setupDevice(tun tunDevice, addrCIDR string, mtu int) error {
+	if err := tun.AddAddressCIDR(addrCIDR); err != nil {
+		return err
+	}
 	if err := tun.SetMTU(mtu); err != nil {
-		return nil, err
+		return err
 	}
+	return tun.SetUP()
+}
 
-	if err := tun.SetUP(); err != nil {
-		return nil, err
+// Close removes all devices allocated by this dialer.
+func (d *dialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	for _, dev := range d.devices {
+		if err := dev.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return tun, nil
+	d.devices = nil
+
+	return errors.Join(errs...)
 }
 
 func init() {
@@ -62,10 +91,10 @@ func init() {
 		Examples: []string{
 			"# gcat proxy 'tun://10.0.0.1/24?dev=tun%d' -",
 		},
-		Dialer: &dialer{},
+		NewDialer: func() proxy.ProxyDialer { return &dialer{} },
 		StringOptions: []proxy.ProxyOption[string]{
 			{
-				Name:        "Host",
+				Name:        "Hostname",
 				Description: "IP address to assign to the device",
 				Default:     "10.0.0.1",
 			},
@@ -80,7 +109,7 @@ func init() {
 				Default:     "gcat-tun%d",
 			},
 		},
-		IntOptions: []proxy.ProxyOption[int]{
+		SizeOptions: []proxy.ProxyOption[proxy.Size]{
 			{
 				Name:        "mtu",
 				Description: "mtu of the allocated 'tun' device",
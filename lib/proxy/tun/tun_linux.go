@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"sync"
 
 	"github.com/rumpelsepp/gcat/lib/proxy"
 	"github.com/vishvananda/netlink"
@@ -15,6 +16,9 @@ type nativeTUN struct {
 	*os.File
 	netlink.Link
 	baseConn proxy.BaseConn
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 func createNativeTUN(dev string) (*nativeTUN, error) {
@@ -62,12 +66,22 @@ func createNativeTUN(dev string) (*nativeTUN, error) {
 		File: link.Fds[0]}, nil
 }
 
+// Close releases the device. Non persistent devices vanish with their
+// last file descriptor; the link is only deleted if it is still around.
+// Close is idempotent since the dialer closes its devices as well.
 func (tun *nativeTUN) Close() error {
+	tun.closeOnce.Do(func() {
+		tun.closeErr = tun.close()
+	})
+	return tun.closeErr
+}
+
+func (tun *nativeTUN) close() error {
 	if err := tun.File.Close(); err != nil {
 		return err
 	}
-	if err := netlink.LinkSetDown(tun.Link); err != nil {
-		return err
+	if _, err := netlink.LinkByIndex(tun.Index()); err != nil {
+		return nil
 	}
 	return netlink.LinkDel(tun.Link)
 }
@@ -80,6 +94,16 @@ func (tun *nativeTUN) RemoteAddr() net.Addr {
 	return tun.baseConn.RemoteAddr()
 }
 
+// ConnInfo marks the device as packet endpoint; every Read() returns
+// one IP packet.
+func (tun *nativeTUN) ConnInfo() proxy.ConnInfo {
+	return proxy.ConnInfo{
+		LocalAddr:  tun.LocalAddr(),
+		RemoteAddr: tun.RemoteAddr(),
+		Datagrams:  true,
+	}
+}
+
 func (tun *nativeTUN) Index() int {
 	return tun.Link.Attrs().Index
 }
@@ -34,11 +34,16 @@ func (l *unixListener) Listen(prox *proxy.ProxyDescription) error {
 	return nil
 }
 
-func (l *unixListener) Accept() (net.Conn, error) {
-	return l.listener.Accept()
+func (l *unixListener) Accept(ctx context.Context) (net.Conn, error) {
+	return proxy.AcceptContext(ctx, l.listener)
 }
 
+// Close removes the socket file as well; net.UnixListener unlinks
+// the sockets it has created.
 func (l *unixListener) Close() error {
+	if !l.IsListening() {
+		return nil
+	}
 	return l.listener.Close()
 }
 
@@ -76,11 +81,16 @@ func (l *unixpacketListener) Listen(prox *proxy.ProxyDescription) error {
 	return nil
 }
 
-func (l *unixpacketListener) Accept() (net.Conn, error) {
-	return l.listener.Accept()
+func (l *unixpacketListener) Accept(ctx context.Context) (net.Conn, error) {
+	return proxy.AcceptContext(ctx, l.listener)
 }
 
+// Close removes the socket file as well; net.UnixListener unlinks
+// the sockets it has created.
 func (l *unixpacketListener) Close() error {
+	if !l.IsListening() {
+		return nil
+	}
 	return l.listener.Close()
 }
 
@@ -93,11 +103,12 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "unix",
 		Description:      "dial unix domain socket (SOCK_STREAM)",
+		Network:          true,
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat unix:///tmp.sock -",
 		},
-		Dialer:        &unixDialer{},
+		NewDialer:     func() proxy.ProxyDialer { return &unixDialer{} },
 		StringOptions: []proxy.ProxyOption[string]{pathOption},
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
@@ -106,9 +117,11 @@ func init() {
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat unix-listen:///tmp.sock -",
+			"$ gcat unix-listen:///tmp.sock?allow_uid=root&allow_uid=1000 -",
 		},
-		Listener:      &unixListener{},
+		NewListener:   func() proxy.ProxyListener { return &unixListener{} },
 		StringOptions: []proxy.ProxyOption[string]{pathOption},
+		ListOptions:   proxy.UIDOptions,
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "unixgram",
@@ -117,17 +130,18 @@ func init() {
 		Examples: []string{
 			"$ gcat unixgram:///tmp.sock -",
 		},
-		Dialer:        &unixgramDialer{},
+		NewDialer:     func() proxy.ProxyDialer { return &unixgramDialer{} },
 		StringOptions: []proxy.ProxyOption[string]{pathOption},
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "unixpacket",
 		Description:      "dial unix domain socket (SOCK_SEQPACKET)",
+		Network:          true,
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat unixpacket:///tmp.sock -",
 		},
-		Dialer:        &unixpacketDialer{},
+		NewDialer:     func() proxy.ProxyDialer { return &unixpacketDialer{} },
 		StringOptions: []proxy.ProxyOption[string]{pathOption},
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
@@ -137,7 +151,8 @@ func init() {
 		Examples: []string{
 			"$ gcat unixpacket-listen:///tmp.sock -",
 		},
-		Listener:      &unixpacketListener{},
+		NewListener:   func() proxy.ProxyListener { return &unixpacketListener{} },
 		StringOptions: []proxy.ProxyOption[string]{pathOption},
+		ListOptions:   proxy.UIDOptions,
 	})
 }
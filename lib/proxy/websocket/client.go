@@ -4,42 +4,133 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
 
 	"github.com/rumpelsepp/gcat/lib/proxy"
 	"nhooyr.io/websocket"
 )
 
+type wsClientConn struct {
+	net.Conn
+	info proxy.ConnInfo
+}
+
+func (c *wsClientConn) ConnInfo() proxy.ConnInfo {
+	return c.info
+}
+
+// The addresses of websocket.NetConn() are meaningless.
+func (c *wsClientConn) LocalAddr() net.Addr {
+	return c.info.LocalAddr
+}
+
+func (c *wsClientConn) RemoteAddr() net.Addr {
+	return c.info.RemoteAddr
+}
+
 type dialer struct{}
 
 func (p *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Conn, error) {
 	var (
-		target  = fmt.Sprintf("%s://%s%s", desc.Scheme, desc.TargetHost(), desc.GetStringOption("Path"))
-		options = websocket.DialOptions{}
+		dialer net.Dialer
+		port   = desc.GetStringOption("Port")
 	)
-	wsConn, _, err := websocket.Dial(ctx, target, &options)
+
+	if port == "" {
+		port = "80"
+		if desc.Scheme == "wss" {
+			port = "443"
+		}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(desc.GetStringOption("Hostname"), port))
+	if err != nil {
+		return nil, err
+	}
+
+	wsConn, err := p.DialConn(ctx, desc, conn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return websocket.NetConn(ctx, wsConn, websocket.MessageBinary), nil
+	return wsConn, nil
+}
+
+// DialConn runs the websocket handshake on conn. For `wss` the
+// http transport performs the TLS handshake on top of conn.
+func (p *dialer) DialConn(ctx context.Context, desc *proxy.ProxyDescription, conn net.Conn) (net.Conn, error) {
+	// The inner module might not have a hostname, e.g. `ws+unix`.
+	host := desc.Target().Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	var (
+		target = url.URL{
+			Scheme: string(desc.Scheme),
+			Host:   host,
+			Path:   desc.GetStringOption("Path"),
+		}
+		connUsed  atomic.Bool
+		transport = &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if !connUsed.CompareAndSwap(false, true) {
+					return nil, fmt.Errorf("stacked connection already in use")
+				}
+				return conn, nil
+			},
+		}
+		options = websocket.DialOptions{
+			HTTPClient: &http.Client{Transport: transport},
+		}
+	)
+
+	wsConn, resp, err := websocket.Dial(ctx, target.String(), &options)
+	if err != nil {
+		return nil, err
+	}
+
+	info := proxy.ConnInfo{
+		LocalAddr:  conn.LocalAddr(),
+		RemoteAddr: conn.RemoteAddr(),
+		Header:     resp.Header,
+		TLS:        resp.TLS,
+		Datagrams:  desc.GetBoolOption("messages"),
+	}
+	if resp.TLS != nil {
+		info.ALPN = resp.TLS.NegotiatedProtocol
+	}
+
+	return &wsClientConn{
+		Conn: newConn(ctx, wsConn, info.Datagrams),
+		info: info,
+	}, nil
 }
 
 func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "ws",
 		Description: "connect websocket host over http",
-		Dialer:      &dialer{},
+		Network:     true,
+		NewDialer:   func() proxy.ProxyDialer { return &dialer{} },
 		Examples: []string{
 			"$ gcat proxy ws://localhost:1234 -",
+			"$ gcat proxy ws+unix:///run/gcat.sock -",
 		},
 		StringOptions: options,
+		BoolOptions:   boolOptions,
 	})
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:      "wss",
 		Description: "connect websocket host over https",
-		Dialer:      &dialer{},
+		Network:     true,
+		NewDialer:   func() proxy.ProxyDialer { return &dialer{} },
 		Examples: []string{
 			"$ gcat proxy wss://localhost:1234 -",
 		},
 		StringOptions: options,
+		BoolOptions:   boolOptions,
 	})
 }
@@ -1,7 +1,18 @@
 package websocket
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
 	"github.com/rumpelsepp/gcat/lib/proxy"
+	"nhooyr.io/websocket"
 )
 
 var options = []proxy.ProxyOption[string]{
@@ -18,3 +29,119 @@ var options = []proxy.ProxyOption[string]{
 		Description: "http path",
 	},
 }
+
+var boolOptions = []proxy.ProxyOption[bool]{
+	{
+		Name:        "messages",
+		Description: "preserve message boundaries; every websocket message is one datagram",
+		Default:     false,
+	},
+}
+
+// errReadDeadline is returned by SetReadDeadline(). An expired
+// deadline closes a websocket connection; thus, it cannot be renewed
+// like the deadlines of idle timeouts. Callers fall back to timers;
+// SetDeadline() is supported for aborting handshakes.
+var errReadDeadline = errors.New("read deadlines close websocket connections")
+
+// newConn converts wsConn into a net.Conn. With messages, each
+// Read() returns one websocket message instead of an arbitrary part
+// of the stream.
+func newConn(ctx context.Context, wsConn *websocket.Conn, messages bool) net.Conn {
+	conn := websocket.NetConn(ctx, wsConn, websocket.MessageBinary)
+	if !messages {
+		return &streamConn{conn}
+	}
+
+	wsConn.SetReadLimit(helper.MaxDatagramSize)
+	return &messageConn{Conn: conn, ws: wsConn, ctx: ctx}
+}
+
+// streamConn is NetConn() without read deadlines.
+type streamConn struct {
+	net.Conn
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return errReadDeadline
+}
+
+// messageConn uses the embedded NetConn() for everything but
+// reading; every Write() of NetConn() already is one message.
+type messageConn struct {
+	net.Conn
+	ws  *websocket.Conn
+	ctx context.Context
+
+	mutex    sync.Mutex
+	deadline time.Time
+
+	readMutex sync.Mutex
+	pending   []byte
+	eof       bool
+}
+
+func (c *messageConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	if len(c.pending) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = msg
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *messageConn) readMessage() ([]byte, error) {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+
+	ctx, cancel := c.ctx, context.CancelFunc(func() {})
+	if !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	defer cancel()
+
+	// Like NetConn(), an expired deadline closes the connection.
+	typ, msg, err := c.ws.Read(ctx)
+	if err != nil {
+		switch websocket.CloseStatus(err) {
+		case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+			c.eof = true
+			return nil, io.EOF
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, os.ErrDeadlineExceeded
+		}
+		return nil, err
+	}
+	if typ != websocket.MessageBinary {
+		err := fmt.Errorf("unexpected frame type read (expected %v): %v", websocket.MessageBinary, typ)
+		c.ws.Close(websocket.StatusUnsupportedData, err.Error())
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *messageConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.deadline = t
+	c.mutex.Unlock()
+
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *messageConn) SetReadDeadline(t time.Time) error {
+	return errReadDeadline
+}
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rumpelsepp/gcat/lib/helper"
+	"nhooyr.io/websocket"
+)
+
+// wsPair returns both ends of a websocket connection.
+func wsPair(t *testing.T, messages bool) (net.Conn, net.Conn) {
+	t.Helper()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		serverCh    = make(chan net.Conn, 1)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsConn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverCh <- newConn(ctx, wsConn, messages)
+		<-ctx.Done()
+	}))
+	t.Cleanup(func() {
+		cancel()
+		srv.Close()
+	})
+
+	wsConn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newConn(ctx, wsConn, messages)
+	t.Cleanup(func() { client.Close() })
+
+	return client, <-serverCh
+}
+
+// TestIdleTimeoutOneWay checks that a session stays alive while data
+// flows in one direction only; read deadlines would close the quiet
+// websocket side.
+func TestIdleTimeoutOneWay(t *testing.T) {
+	for _, messages := range []bool{false, true} {
+		client, server := wsPair(t, messages)
+		pipe, pipePeer := net.Pipe()
+		defer pipePeer.Close()
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, _, err := helper.BidirectCopyTimeout(server, pipe, helper.CopyTimeouts{Idle: 100 * time.Millisecond})
+			errCh <- err
+		}()
+
+		// Only the pipe is sending; the websocket is quiet.
+		buf := make([]byte, 4)
+		for i := 0; i < 10; i++ {
+			if _, err := pipePeer.Write([]byte("ping")); err != nil {
+				t.Fatalf("messages=%v: write %d: %s", messages, i, err)
+			}
+			if _, err := io.ReadFull(client, buf); err != nil {
+				t.Fatalf("messages=%v: read %d: %s", messages, i, err)
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+
+		// Closing a websocket waits for the close frame of the peer.
+		go io.Copy(io.Discard, client)
+
+		select {
+		case err := <-errCh:
+			if !errors.Is(err, helper.ErrIdleTimeout) {
+				t.Fatalf("messages=%v: expected idle timeout; got %v", messages, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("messages=%v: idle timeout did not fire", messages)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 
 	"github.com/jba/muxpatterns" // will be included in the stdlib
 	"github.com/rumpelsepp/gcat/lib/helper"
@@ -18,6 +19,20 @@ type wsConnWrapper struct {
 	doneCh   chan bool
 	context  context.Context
 	cancel   context.CancelCauseFunc
+	info     proxy.ConnInfo
+}
+
+func (w *wsConnWrapper) ConnInfo() proxy.ConnInfo {
+	return w.info
+}
+
+// The addresses of websocket.NetConn() are meaningless.
+func (w *wsConnWrapper) LocalAddr() net.Addr {
+	return w.info.LocalAddr
+}
+
+func (w *wsConnWrapper) RemoteAddr() net.Addr {
+	return w.info.RemoteAddr
 }
 
 func (w *wsConnWrapper) Close() error {
@@ -41,27 +56,40 @@ type listener struct {
 	errorCh     chan error
 	httpServer  *http.Server
 	isListening bool
+	messages    bool
+	access      *helper.AccessPolicy
 	context     context.Context
 }
 
 func (ln *listener) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	// Reject before the upgrade; ws-listen might be stacked on
+	// listeners without access options.
+	if err := ln.access.CheckAddr(requestConnInfo(r).RemoteAddr); err != nil {
+		helper.LogRejected(r.RemoteAddr, err, "proxy", "ws-listen")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
 	wsConn, err := websocket.Accept(w, r, nil)
 	if err != nil {
-		fmt.Println(err)
+		helper.GetLogger().Warn("websocket handshake failed", "remote", r.RemoteAddr, "error", err)
+		helper.Event(helper.EventRejected, "proxy", "ws-listen", "remote", r.RemoteAddr, "error", err.Error())
 		return
 	}
 
 	ctx, cancel := context.WithCancelCause(r.Context())
 
 	var (
-		conn        = websocket.NetConn(ln.context, wsConn, websocket.MessageBinary)
+		conn        = newConn(ln.context, wsConn, ln.messages)
 		wrappedConn = &wsConnWrapper{
 			Conn:    conn,
 			doneCh:  make(chan bool),
 			context: ctx,
 			cancel:  cancel,
+			info:    requestConnInfo(r),
 		}
 	)
+	wrappedConn.info.Datagrams = ln.messages
 
 	ln.newConnCh <- wrappedConn
 
@@ -73,7 +101,46 @@ func (ln *listener) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func requestConnInfo(r *http.Request) proxy.ConnInfo {
+	info := proxy.ConnInfo{
+		TLS:    r.TLS,
+		Header: r.Header.Clone(),
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		info.LocalAddr = addr
+	}
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		info.RemoteAddr = net.TCPAddrFromAddrPort(addrPort)
+	}
+	if r.TLS != nil {
+		info.ALPN = r.TLS.NegotiatedProtocol
+	}
+
+	return info
+}
+
 func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
+	tcpListener, err := net.Listen("tcp", desc.TargetHost())
+	if err != nil {
+		return err
+	}
+
+	if err := ln.ListenOn(desc, tcpListener); err != nil {
+		tcpListener.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (ln *listener) ListenOn(desc *proxy.ProxyDescription, inner net.Listener) error {
+	access, err := desc.AccessPolicy()
+	if err != nil {
+		return err
+	}
+	ln.access = access
+
 	handler := muxpatterns.NewServeMux()
 	handler.HandleFunc(fmt.Sprintf("GET %s", desc.GetStringOption("Path")), ln.handleWebsocket)
 
@@ -83,12 +150,13 @@ func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
 	}
 
 	ln.httpServer = server
+	ln.messages = desc.GetBoolOption("messages")
 
 	newConnCh := make(chan *wsConnWrapper)
 	ln.newConnCh = newConnCh
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.Serve(inner); err != nil {
 			ln.errorCh <- err
 		}
 	}()
@@ -98,12 +166,14 @@ func (ln *listener) Listen(desc *proxy.ProxyDescription) error {
 	return nil
 }
 
-func (ln *listener) Accept() (net.Conn, error) {
+func (ln *listener) Accept(ctx context.Context) (net.Conn, error) {
 	select {
 	case conn := <-ln.newConnCh:
 		return conn, nil
 	case err := <-ln.errorCh:
 		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
@@ -115,22 +185,26 @@ func (ln *listener) Close() error {
 	return ln.httpServer.Shutdown(ln.context)
 }
 
-func init() {
-	ln := &listener{
+func newListener() proxy.ProxyListener {
+	return &listener{
 		newConnCh:   make(chan *wsConnWrapper),
 		errorCh:     make(chan error),
 		isListening: false,
 		context:     context.Background(),
 	}
+}
 
+func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "ws-listen",
 		Description:      "serve websocket",
-		Listener:         ln,
+		NewListener:      newListener,
 		SupportsMultiple: true,
 		Examples: []string{
 			"$ gcat proxy ws-listen://localhost:1234/ws -",
 		},
 		StringOptions: options,
+		BoolOptions:   boolOptions,
+		ListOptions:   proxy.AccessOptions,
 	})
 }
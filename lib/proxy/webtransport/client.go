@@ -12,6 +12,16 @@ import (
 type streamWrapper struct {
 	webtransport.Stream
 	*webtransport.Session
+	info proxy.ConnInfo
+}
+
+func (s *streamWrapper) ConnInfo() proxy.ConnInfo {
+	return s.info
+}
+
+// CloseWrite finishes the sending direction of the stream only.
+func (s *streamWrapper) CloseWrite() error {
+	return s.Stream.Close()
 }
 
 func (s *streamWrapper) Close() error {
@@ -32,7 +42,7 @@ func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Co
 		dialer webtransport.Dialer
 		url    = fmt.Sprintf("https://%s/%s", desc.TargetHost(), desc.GetStringOption("Path"))
 	)
-	_, session, err := dialer.Dial(ctx, url, nil)
+	resp, session, err := dialer.Dial(ctx, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +55,11 @@ func (d *dialer) Dial(ctx context.Context, desc *proxy.ProxyDescription) (net.Co
 	return &streamWrapper{
 		Session: session,
 		Stream:  stream,
+		info: proxy.ConnInfo{
+			LocalAddr:  session.LocalAddr(),
+			RemoteAddr: session.RemoteAddr(),
+			Header:     resp.Header,
+		},
 	}, nil
 }
 
@@ -52,8 +67,9 @@ func init() {
 	proxy.Registry.Add(proxy.ProxyDescription{
 		Scheme:           "wt",
 		Description:      "dial to a webtransport endpoint",
+		Network:          true,
 		SupportsMultiple: true,
-		Dialer:           &dialer{},
+		NewDialer:        func() proxy.ProxyDialer { return &dialer{} },
 		Examples: []string{
 			"$ gcat proxy wt://localhost:1234/wt -",
 		},
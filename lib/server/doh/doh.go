@@ -6,13 +6,16 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/netip"
 	"sync"
+	"time"
 
 	"github.com/jba/muxpatterns"
 	"github.com/miekg/dns"
 	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
 )
 
 const mime = "application/dns-message"
@@ -28,6 +31,17 @@ type DoHServer struct {
 	RequestLog  string
 	Path        string
 	Listen      string
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// Access restricts the clients if set.
+	Access *helper.AccessPolicy
+}
+
+func (s *DoHServer) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
 }
 
 func (s *DoHServer) nextIndex() int {
@@ -40,10 +54,18 @@ func (s *DoHServer) nextIndex() int {
 }
 
 func (s *DoHServer) proxyDNSRequest(question *dns.Msg) (*dns.Msg, error) {
-	resp, err := dns.Exchange(question, s.Upstreams[s.nextIndex()].String())
+	var (
+		upstream = s.Upstreams[s.nextIndex()].String()
+		start    = time.Now()
+	)
+
+	resp, err := dns.Exchange(question, upstream)
+	metrics.DoHQueryDuration.Observe(time.Since(start).Seconds(), upstream)
 	if err != nil {
+		metrics.DoHQueries.Inc(upstream, "error")
 		return nil, err
 	}
+	metrics.DoHQueries.Inc(upstream, dns.RcodeToString[resp.Rcode])
 	return resp, nil
 }
 
@@ -69,7 +91,7 @@ func (s *DoHServer) finishRequest(resp *dns.Msg, w http.ResponseWriter, r *http.
 		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", cacheTTL))
 	}
 	if _, err := io.Copy(w, bytes.NewReader(buf)); err != nil {
-		fmt.Println(err)
+		s.logger().Warn("writing response failed", "remote", r.RemoteAddr, "error", err)
 	}
 }
 
@@ -101,7 +123,7 @@ func (s *DoHServer) getRequest(w http.ResponseWriter, r *http.Request) {
 func (s *DoHServer) postRequest(w http.ResponseWriter, r *http.Request) {
 	rawQuestion, err := io.ReadAll(r.Body)
 	if err != nil {
-		fmt.Println(err)
+		s.logger().Warn("reading request failed", "remote", r.RemoteAddr, "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -109,7 +131,7 @@ func (s *DoHServer) postRequest(w http.ResponseWriter, r *http.Request) {
 
 	var question dns.Msg
 	if err := question.Unpack(rawQuestion); err != nil {
-		fmt.Println(err)
+		s.logger().Warn("invalid dns message", "remote", r.RemoteAddr, "error", err)
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
@@ -131,8 +153,5 @@ func (s *DoHServer) Run() error {
 		return err
 	}
 
-	if s.TLSCertFile != "" && s.TLSKeyFile != "" {
-		return httpServer.ListenAndServeTLS(s.TLSCertFile, s.TLSKeyFile)
-	}
-	return httpServer.ListenAndServe()
+	return helper.ListenAndServeHTTP(httpServer, "doh", s.TLSCertFile, s.TLSKeyFile, s.Access)
 }
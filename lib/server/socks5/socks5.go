@@ -8,10 +8,13 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/rumpelsepp/gcat/lib/helper"
+	"github.com/rumpelsepp/gcat/lib/metrics"
 )
 
 const (
@@ -103,6 +106,51 @@ type Server struct {
 	Auth     int
 	Username string
 	Password string
+	// HandshakeTimeout limits the SOCKS negotiation.
+	HandshakeTimeout time.Duration
+	// Timeouts limit the relayed connections.
+	Timeouts helper.CopyTimeouts
+	// Dumper prints the relayed traffic if set.
+	Dumper *helper.Dumper
+	// Access restricts the clients if set.
+	Access *helper.AccessPolicy
+	// RateUp and RateDown limit the bandwidth per user in bytes per
+	// second; all connections of a user share the limit, without
+	// authentication all clients. 0 means unlimited.
+	RateUp   int64
+	RateDown int64
+
+	sessions atomic.Uint64
+
+	mutex sync.Mutex
+	rates map[string]*userRates
+}
+
+type userRates struct {
+	up, down *helper.RateLimiter
+}
+
+// userRates returns the limiters of user, creating them on first
+// use.
+func (s *Server) userRates(user string) *userRates {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rates == nil {
+		s.rates = make(map[string]*userRates)
+	}
+	r, ok := s.rates[user]
+	if !ok {
+		r = &userRates{}
+		if s.RateUp > 0 {
+			r.up = helper.NewRateLimiter(s.RateUp)
+		}
+		if s.RateDown > 0 {
+			r.down = helper.NewRateLimiter(s.RateDown)
+		}
+		s.rates[user] = r
+	}
+	return r
 }
 
 func (s *Server) readHandshake(conn io.ReadWriteCloser) (byte, error) {
@@ -288,7 +336,53 @@ func (s *Server) sendError(conn io.ReadWriteCloser, code byte) error {
 	return s.sendReply(conn, code, AddrIPv4, []byte{0, 0, 0, 0}, 0)
 }
 
+func commandName(cmd byte) string {
+	switch cmd {
+	case CmdConnect:
+		return "connect"
+	case CmdBind:
+		return "bind"
+	case CmdUDPAssociate:
+		return "udp_associate"
+	}
+	return fmt.Sprintf("0x%02x", cmd)
+}
+
+func replyName(code byte) string {
+	switch code {
+	case RepSucceeded:
+		return "succeeded"
+	case RepGeneralSOCKSServerFailure:
+		return "general_failure"
+	case RepConnectionNotAllowed:
+		return "not_allowed"
+	case RepNetworkUnreachable:
+		return "network_unreachable"
+	case RepHostUnreachable:
+		return "host_unreachable"
+	case RepConnectionRefused:
+		return "connection_refused"
+	case RepTTLExpired:
+		return "ttl_expired"
+	case RepCommandNotSupported:
+		return "command_not_supported"
+	case RepAddressTypeNotSupported:
+		return "address_type_not_supported"
+	}
+	return fmt.Sprintf("0x%02x", code)
+}
+
+// remoteAddr returns the peer of conn if it has one.
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(net.Conn); ok {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
 func (s *Server) serveClient(conn io.ReadWriteCloser) error {
+	stopTimer := helper.StartHandshakeTimer(conn, s.HandshakeTimeout)
+
 	auth, err := s.readHandshake(conn)
 	if err != nil {
 		if err == ErrNoAcceptableMethods {
@@ -301,6 +395,7 @@ func (s *Server) serveClient(conn io.ReadWriteCloser) error {
 		conn.Close()
 		return err
 	}
+	var user string
 	if auth != AuthNoAuthRequired {
 		switch auth {
 		case AuthUsernamePassword:
@@ -310,6 +405,7 @@ func (s *Server) serveClient(conn io.ReadWriteCloser) error {
 				return err
 			}
 			if string(req.UNAME) != s.Username || string(req.PASSWD) != s.Password {
+				helper.Event(helper.EventRejected, "service", "socks5", "remote", remoteAddr(conn), "user", string(req.UNAME), "error", "invalid credentials")
 				s.sendUsernamePasswordReply(conn, 1)
 				conn.Close()
 				return err
@@ -318,6 +414,7 @@ func (s *Server) serveClient(conn io.ReadWriteCloser) error {
 				conn.Close()
 				return err
 			}
+			user = string(req.UNAME)
 		default:
 			// This is a bug. It fails earlier in readHandshake().
 			panic("BUG: auth method")
@@ -328,6 +425,11 @@ func (s *Server) serveClient(conn io.ReadWriteCloser) error {
 		conn.Close()
 		return err
 	}
+	if err := stopTimer(); err != nil {
+		conn.Close()
+		return err
+	}
+	helper.Event(helper.EventHandshake, "service", "socks5", "remote", remoteAddr(conn), "command", req.CMD)
 
 	switch req.CMD {
 	case CmdConnect:
@@ -351,34 +453,46 @@ func (s *Server) serveClient(conn io.ReadWriteCloser) error {
 		}
 		upstreamConn, err := net.DialTimeout("tcp", host, 2*time.Second)
 		if err != nil {
+			code := byte(RepGeneralSOCKSServerFailure)
 			if errors.Is(err, syscall.ECONNREFUSED) {
-				if err := s.sendError(conn, RepConnectionRefused); err != nil {
-					return err
-				}
+				code = RepConnectionRefused
 			} else if errors.Is(err, syscall.EHOSTUNREACH) {
-				if err := s.sendError(conn, RepHostUnreachable); err != nil {
-					return err
-				}
+				code = RepHostUnreachable
 			} else if errors.Is(err, syscall.ENETUNREACH) {
-				if err := s.sendError(conn, RepNetworkUnreachable); err != nil {
-					return err
-				}
+				code = RepNetworkUnreachable
 			}
+			metrics.SOCKS5Requests.Inc(commandName(req.CMD), replyName(code))
+			s.sendError(conn, code)
+			conn.Close()
 			return err
 		}
+		helper.Event(helper.EventDialed, "service", "socks5", "remote", remoteAddr(conn), "target", host, "upstream", upstreamConn.RemoteAddr().String())
+		metrics.SOCKS5Requests.Inc(commandName(req.CMD), replyName(RepSucceeded))
 		if err := s.sendReply(conn, RepSucceeded, req.ATYP, addr, req.DSTPort); err != nil {
 			upstreamConn.Close()
 			return err
 		}
-		if _, _, err = helper.BidirectCopy(upstreamConn, conn); err != nil {
+		client := conn
+		if s.RateUp > 0 || s.RateDown > 0 {
+			rates := s.userRates(user)
+			client = helper.RateLimit(client, []*helper.RateLimiter{rates.up}, []*helper.RateLimiter{rates.down})
+		}
+		if s.Dumper != nil {
+			client = s.Dumper.Wrap(client, s.sessions.Add(1))
+		}
+		if _, _, err = helper.BidirectCopyTimeout(upstreamConn, client, s.Timeouts); err != nil {
 			return err
 		}
+	default:
+		metrics.SOCKS5Requests.Inc(commandName(req.CMD), replyName(RepCommandNotSupported))
+		s.sendError(conn, RepCommandNotSupported)
+		conn.Close()
 	}
 	return nil
 }
 
 func (s *Server) ListenAndServe() error {
-	ln, err := net.Listen("tcp", s.Listen)
+	ln, err := helper.EventListen("tcp", s.Listen, "socks5", s.Access)
 	if err != nil {
 		return err
 	}
@@ -18,7 +18,10 @@ package gssh
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"os/user"
 
@@ -43,14 +46,39 @@ func (srv *SSHServer) createPty(s ssh.Session, shell string) error {
 	if err != nil {
 		return err
 	}
+
+	var (
+		term io.ReadWriteCloser = f
+		rec  *helper.Recorder
+	)
+	if srv.RecordDir != "" {
+		var recFile *os.File
+		rec, recFile, err = srv.startRecording(s, ptyReq, shell)
+		if err != nil {
+			srv.logger.Error("Could not record pty session", "error", err)
+		} else {
+			defer recFile.Close()
+			term = rec.Wrap(f)
+		}
+	}
+
 	go func() {
 		for win := range winCh {
 			winSize := &pty.Winsize{Rows: uint16(win.Height), Cols: uint16(win.Width)}
 			pty.Setsize(f, winSize)
+			if rec != nil && win.Width > 0 && win.Height > 0 {
+				rec.Resize(win.Width, win.Height)
+			}
 		}
 	}()
 
-	go helper.BidirectCopy(f, s)
+	go func() {
+		_, _, err := helper.BidirectCopyTimeout(term, s, srv.Timeouts)
+		if errors.Is(err, helper.ErrIdleTimeout) || errors.Is(err, helper.ErrLifetimeExceeded) {
+			srv.logger.Info("Closing pty session", "reason", err)
+			s.Close()
+		}
+	}()
 
 	done := make(chan error, 1)
 	go func() { done <- cmd.Wait() }()
@@ -58,7 +86,7 @@ func (srv *SSHServer) createPty(s ssh.Session, shell string) error {
 	select {
 	case err := <-done:
 		if err != nil {
-			srv.logger.Error("Session ended with error", err)
+			srv.logger.Error("Session ended with error", "error", err)
 			s.Exit(255)
 			return err
 		}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gliderlabs/ssh"
 	"github.com/pkg/sftp"
+	"github.com/rumpelsepp/gcat/lib/helper"
 )
 
 type SSHServer struct {
@@ -23,6 +29,24 @@ type SSHServer struct {
 	User           string
 	Passwd         string
 	Shell          string
+	// Timeouts limit pty sessions; the idle timeout only counts
+	// terminal data. The lifetime also limits the whole connection.
+	Timeouts helper.CopyTimeouts
+	// ConnIdleTimeout closes connections without any traffic,
+	// including SSH keepalives.
+	ConnIdleTimeout time.Duration
+	// HandshakeTimeout limits the SSH handshake up to a successful
+	// authentication.
+	HandshakeTimeout time.Duration
+	// RecordDir receives an asciicast recording of every pty session
+	// if set.
+	RecordDir string
+	// Access restricts the clients if set.
+	Access *helper.AccessPolicy
+
+	recordings atomic.Uint64
+	mutex      sync.Mutex
+	handshakes map[ssh.Context]*time.Timer
 }
 
 func NewSSHServer() *SSHServer {
@@ -31,31 +55,98 @@ func NewSSHServer() *SSHServer {
 	}
 }
 
+// startHandshake closes conn unless authenticated() is called for
+// ctx within srv.HandshakeTimeout.
+func (srv *SSHServer) startHandshake(ctx ssh.Context, conn net.Conn) net.Conn {
+	if srv.HandshakeTimeout <= 0 {
+		return conn
+	}
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	if srv.handshakes == nil {
+		srv.handshakes = make(map[ssh.Context]*time.Timer)
+	}
+	srv.handshakes[ctx] = time.AfterFunc(srv.HandshakeTimeout, func() {
+		srv.logger.Warn("Handshake timeout", "remote", conn.RemoteAddr().String())
+		srv.stopHandshakeTimer(ctx)
+		conn.Close()
+	})
+
+	go func() {
+		<-ctx.Done()
+		srv.stopHandshakeTimer(ctx)
+	}()
+
+	return conn
+}
+
+// authenticated is called by the successful auth callbacks.
+func (srv *SSHServer) authenticated(ctx ssh.Context) {
+	helper.Event(helper.EventHandshake, "service", "ssh", "user", ctx.User(), "remote", ctx.RemoteAddr().String())
+	srv.stopHandshakeTimer(ctx)
+}
+
+func (srv *SSHServer) stopHandshakeTimer(ctx ssh.Context) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	if timer, ok := srv.handshakes[ctx]; ok {
+		timer.Stop()
+		delete(srv.handshakes, ctx)
+	}
+}
+
+// startRecording creates the recording of the pty session s in
+// srv.RecordDir; the caller must close the returned file.
+func (srv *SSHServer) startRecording(s ssh.Session, ptyReq ssh.Pty, shell string) (*helper.Recorder, *os.File, error) {
+	name := fmt.Sprintf("%s-%s-%d.cast", time.Now().Format("20060102-150405"), s.User(), srv.recordings.Add(1))
+	f, err := os.OpenFile(filepath.Join(srv.RecordDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rec, err := helper.NewRecorder(f, helper.CastHeader{
+		Width:  ptyReq.Window.Width,
+		Height: ptyReq.Window.Height,
+		Title:  fmt.Sprintf("%s@%s", s.User(), s.RemoteAddr()),
+		Env:    map[string]string{"TERM": ptyReq.Term, "SHELL": shell},
+	})
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	srv.logger.Info("Recording pty session", "user", s.User(), "remote", s.RemoteAddr().String(), "file", f.Name())
+	return rec, f, nil
+}
+
 func (srv *SSHServer) sftpHandler(s ssh.Session) {
 	server, err := sftp.NewServer(s)
 	if err != nil {
-		srv.logger.Error("SFTP server init error: %s\n", err)
+		srv.logger.Error("SFTP server init error", "error", err)
 		return
 	}
 
-	srv.logger.Debug(fmt.Sprintf("New SFTP connection from %s", s.RemoteAddr().String()))
+	srv.logger.Debug("New SFTP connection", "remote", s.RemoteAddr().String())
 	if err := server.Serve(); err == io.EOF {
 		server.Close()
 		srv.logger.Debug("SFTP connection closed by client")
 	} else if err != nil {
-		srv.logger.Error("SFTP server exited with error: %s", err)
+		srv.logger.Error("SFTP server exited with error", "error", err)
 	}
 }
 
 func (srv *SSHServer) makeSSHSessionHandler(shell string) ssh.Handler {
 	return func(s ssh.Session) {
-		srv.logger.Info("New login from %s@%s", s.User(), s.RemoteAddr().String())
+		srv.logger.Info("New login", "user", s.User(), "remote", s.RemoteAddr().String())
 		_, _, isPty := s.Pty()
 
 		switch {
 		case isPty:
 			if err := srv.createPty(s, shell); err != nil {
-				srv.logger.Error("error serving pty", err)
+				srv.logger.Error("error serving pty", "error", err)
 			}
 			return
 
@@ -64,14 +155,14 @@ func (srv *SSHServer) makeSSHSessionHandler(shell string) ssh.Handler {
 
 			stdin, err := cmd.StdinPipe()
 			if err != nil {
-				srv.logger.Error("Could not initialize StdinPipe", err)
+				srv.logger.Error("Could not initialize StdinPipe", "error", err)
 				s.Exit(1)
 				return
 			}
 
 			go func() {
 				if _, err := io.Copy(stdin, s); err != nil {
-					srv.logger.Error(fmt.Sprintf("copying input from %s to stdin", s.RemoteAddr().String()), err)
+					srv.logger.Error("copying input to stdin failed", "remote", s.RemoteAddr().String(), "error", err)
 				}
 				s.Close()
 			}()
@@ -80,8 +171,8 @@ func (srv *SSHServer) makeSSHSessionHandler(shell string) ssh.Handler {
 			cmd.Stderr = s
 
 			logError := func(str string, err error) {
-				srv.logger.Error(str, err)
-				fmt.Fprintf(s, "%s: %s", str, err)
+				srv.logger.Error(str, "error", err)
+				fmt.Fprintf(s, "%s: %s\n", str, err)
 			}
 
 			done := make(chan error, 1)
@@ -90,7 +181,7 @@ func (srv *SSHServer) makeSSHSessionHandler(shell string) ssh.Handler {
 			select {
 			case err := <-done:
 				if err != nil {
-					logError("Command execution failed: %s\n", err)
+					logError("Command execution failed", err)
 					s.Exit(255)
 					return
 				}
@@ -99,7 +190,7 @@ func (srv *SSHServer) makeSSHSessionHandler(shell string) ssh.Handler {
 				return
 
 			case <-s.Context().Done():
-				srv.logger.Info("Session terminated: %s", s.Context().Err())
+				srv.logger.Info("Session terminated", "reason", s.Context().Err())
 				return
 			}
 
@@ -113,22 +204,27 @@ func (srv *SSHServer) Run() error {
 	var (
 		forwardHandler = &ssh.ForwardedTCPHandler{}
 		server         = ssh.Server{
-			Handler: srv.makeSSHSessionHandler(srv.Shell),
-			Addr:    srv.Address,
+			Handler:      srv.makeSSHSessionHandler(srv.Shell),
+			Addr:         srv.Address,
+			IdleTimeout:  srv.ConnIdleTimeout,
+			MaxTimeout:   srv.Timeouts.Lifetime,
+			ConnCallback: srv.startHandshake,
 			PasswordHandler: func(ctx ssh.Context, pass string) bool {
 				if pass == srv.Passwd {
-					srv.logger.Info(fmt.Sprintf("Successful authentication with password from %s@%s", ctx.User(), ctx.RemoteAddr().String()))
+					srv.authenticated(ctx)
+					srv.logger.Info("Successful authentication with password", "user", ctx.User(), "remote", ctx.RemoteAddr().String())
 					return true
 				}
-				srv.logger.Warn("Invalid password from %s@%s", ctx.User(), ctx.RemoteAddr().String())
+				srv.logger.Warn("Invalid password", "user", ctx.User(), "remote", ctx.RemoteAddr().String())
+				helper.Event(helper.EventRejected, "service", "ssh", "user", ctx.User(), "remote", ctx.RemoteAddr().String(), "error", "invalid password")
 				return false
 			},
 			LocalPortForwardingCallback: func(ctx ssh.Context, dhost string, dport uint32) bool {
-				srv.logger.Info("Accepted forward to %s:%d", dhost, dport)
+				srv.logger.Info("Accepted forward", "host", dhost, "port", dport)
 				return true
 			},
 			ReversePortForwardingCallback: func(ctx ssh.Context, host string, port uint32) bool {
-				srv.logger.Info("Attempt to bind at %s:%d granted", host, port)
+				srv.logger.Info("Attempt to bind granted", "host", host, "port", port)
 				return true
 			},
 			ChannelHandlers: map[string]ssh.ChannelHandler{
@@ -164,7 +260,7 @@ func (srv *SSHServer) Run() error {
 		for scanner.Scan() {
 			key, _, _, _, err := ssh.ParseAuthorizedKey(scanner.Bytes())
 			if err != nil {
-				srv.logger.Warn("Encountered error while parsing public key:", err)
+				srv.logger.Warn("Encountered error while parsing public key", "error", err)
 				continue
 			}
 			keys = append(keys, key)
@@ -173,17 +269,20 @@ func (srv *SSHServer) Run() error {
 		server.PublicKeyHandler = func(ctx ssh.Context, key ssh.PublicKey) bool {
 			for _, authKey := range keys {
 				if bytes.Equal(key.Marshal(), authKey.Marshal()) {
-					srv.logger.Info("Successful authentication with ssh key from %s@%s", ctx.User(), ctx.RemoteAddr().String())
+					srv.authenticated(ctx)
+					srv.logger.Info("Successful authentication with ssh key", "user", ctx.User(), "remote", ctx.RemoteAddr().String())
 					return true
 				}
 			}
-			srv.logger.Info("Invalid ssh key from %s@%s", ctx.User(), ctx.RemoteAddr().String())
+			srv.logger.Info("Invalid ssh key", "user", ctx.User(), "remote", ctx.RemoteAddr().String())
+			helper.Event(helper.EventRejected, "service", "ssh", "user", ctx.User(), "remote", ctx.RemoteAddr().String(), "error", "invalid key")
 			return false
 		}
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	ln, err := helper.EventListen("tcp", srv.Address, "ssh", srv.Access)
+	if err != nil {
 		return err
 	}
-	return nil
+	return server.Serve(ln)
 }
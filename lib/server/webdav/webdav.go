@@ -14,6 +14,8 @@ type WebDAVServer struct {
 	Root   string
 	Listen string
 	Logger *slog.Logger
+	// Access restricts the clients if set.
+	Access *helper.AccessPolicy
 }
 
 func (s *WebDAVServer) Run() error {
@@ -34,7 +36,7 @@ func (s *WebDAVServer) Run() error {
 		return err
 	}
 
-	if err := httpServer.ListenAndServe(); err != nil {
+	if err := helper.ListenAndServeHTTP(httpServer, "webdav", "", "", s.Access); err != nil {
 		return err
 	}
 	return nil